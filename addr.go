@@ -0,0 +1,138 @@
+package neo
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// family is a set of address families.
+type family int
+
+const (
+	familyIPv4 family = 1 << iota
+	familyIPv6
+)
+
+func (f family) has(o family) bool { return f&o != 0 }
+
+// ipFamily returns family of ip. IPv4-mapped IPv6 addresses are IPv4.
+func ipFamily(ip net.IP) family {
+	if ip.To4() != nil {
+		return familyIPv4
+	}
+	return familyIPv6
+}
+
+// socketFamily returns families of packets that socket bound to ip on network
+// can send and receive. Like in package net, sockets of "udp" network bound
+// to the unspecified address are dual-stack, while "udp6" ones are IPv6-only.
+func socketFamily(network string, ip net.IP) family {
+	if !ip.IsUnspecified() {
+		return ipFamily(ip)
+	}
+	switch network {
+	case "udp4":
+		return familyIPv4
+	case "udp6":
+		return familyIPv6
+	default:
+		return familyIPv4 | familyIPv6
+	}
+}
+
+// normalizeIP returns 4-byte representation of IPv4 and IPv4-mapped
+// addresses and ip as is otherwise.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// ipKey returns key of ip in maps, which is the unmapped netip.Addr.
+func ipKey(ip net.IP) netip.Addr {
+	a, _ := netip.AddrFromSlice(ip)
+	return a.Unmap()
+}
+
+// isLinkLocal reports whether ip is IPv6 link-local address, which is
+// meaningful only within a zone.
+func isLinkLocal(ip net.IP) bool {
+	return ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast())
+}
+
+// scopedKey is like ipKey but distinguishes link-local addresses of
+// different zones.
+func scopedKey(ip net.IP, zone string) netip.Addr {
+	if zone != "" && isLinkLocal(ip) {
+		return ipKey(ip).WithZone(zone)
+	}
+	return ipKey(ip)
+}
+
+// splitZone splits host into IP literal and zone.
+func splitZone(host string) (ip, zone string) {
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
+// udpAddr converts a to UDP address with normalized IP.
+func udpAddr(a net.Addr) (*net.UDPAddr, bool) {
+	u := new(net.UDPAddr)
+	if !udpAddrTo(u, a) {
+		return nil, false
+	}
+	return u, true
+}
+
+// udpAddrTo is like udpAddr but sets u.
+func udpAddrTo(u *net.UDPAddr, a net.Addr) bool {
+	if v, ok := a.(*net.UDPAddr); ok {
+		if v == nil {
+			return false
+		}
+		*u = net.UDPAddr{IP: normalizeIP(v.IP), Port: v.Port, Zone: v.Zone}
+		return v.IP != nil
+	}
+	if a == nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return false
+	}
+	host, zone := splitZone(host)
+	*u = net.UDPAddr{IP: normalizeIP(net.ParseIP(host)), Zone: zone}
+	if u.IP == nil {
+		return false
+	}
+	u.Port, err = strconv.Atoi(port)
+	return err == nil
+}
+
+// cloneUDPAddr returns copy of a that shares no memory with it, so that
+// addresses passed to interceptors and receivers can be modified.
+func cloneUDPAddr(a *net.UDPAddr) *net.UDPAddr {
+	// Address and its IP are allocated at once.
+	c := &struct {
+		net.UDPAddr
+		ip [net.IPv6len]byte
+	}{}
+	c.IP = c.ip[:copy(c.ip[:], a.IP)]
+	c.Port, c.Zone = a.Port, a.Zone
+	return &c.UDPAddr
+}
+
+// addrKey returns key of UDP address a in maps. Addresses of other types
+// have the zero key.
+func addrKey(a net.Addr) netip.AddrPort {
+	u, ok := a.(*net.UDPAddr)
+	if !ok || u == nil {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(scopedKey(u.IP, u.Zone), uint16(u.Port))
+}
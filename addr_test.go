@@ -0,0 +1,141 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestNet_ResolveUDPAddr(t *testing.T) {
+	nt := NewNet(nil)
+	for _, tt := range []struct {
+		network string
+		address string
+		result  string
+		ipLen   int
+	}{
+		{"udp", "10.0.0.1:53", "10.0.0.1:53", net.IPv4len},
+		{"udp4", "10.0.0.1:53", "10.0.0.1:53", net.IPv4len},
+		{"udp", "[::ffff:10.0.0.1]:53", "10.0.0.1:53", net.IPv4len},
+		{"udp6", "[fd00::1]:53", "[fd00::1]:53", net.IPv6len},
+		{"udp6", "[fe80::1%eth0]:53", "[fe80::1%eth0]:53", net.IPv6len},
+		{"udp", ":53", ":53", 0},
+		{"udp4", "[fd00::1]:53", "", 0},
+		{"udp6", "10.0.0.1:53", "", 0},
+		{"udp6", "[::ffff:10.0.0.1]:53", "", 0},
+		{"udp", "10.0.0.1%eth0:53", "", 0},
+		{"udp", "10.0.0.1:65536", "", 0},
+		{"udp", "10.0.0.1:dns", "", 0},
+		{"udp", "host:53", "", 0},
+		{"udp", "10.0.0.1", "", 0},
+		{"tcp", "10.0.0.1:53", "", 0},
+	} {
+		a, err := nt.ResolveUDPAddr(tt.network, tt.address)
+		if tt.result == "" {
+			var netErr net.Error
+			if !errors.As(err, &netErr) {
+				t.Errorf("%s %s: expected net.Error, got %v", tt.network, tt.address, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.network, tt.address, err)
+			continue
+		}
+		if a.String() != tt.result || len(a.IP) != tt.ipLen {
+			t.Errorf("%s %s: got %s", tt.network, tt.address, a)
+		}
+	}
+}
+
+func TestNet_DualStack(t *testing.T) {
+	nt := NewNet(nil)
+	h, err := nt.AddHost("10.0.0.1", "fd00::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client4 := listen(t, nt, "10.0.0.2:123")
+	client6 := listen(t, nt, "[fd00::2]:123")
+
+	// received sends packet from client to dst and returns the socket that
+	// received it, if any.
+	received := func(client *PacketConn, dst string, servers ...*PacketConn) *PacketConn {
+		t.Helper()
+		a, err := net.ResolveUDPAddr("udp", dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.WriteTo([]byte("hello"), a); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range servers {
+			if _, ok := s.rx.pop(); ok {
+				return s
+			}
+		}
+		return nil
+	}
+
+	listenHost := func(network, address string) *PacketConn {
+		t.Helper()
+		c, err := h.ListenPacket(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.(*PacketConn)
+	}
+
+	dual := listenHost("udp", ":53")
+	if dual.LocalAddr().String() != "[::]:53" {
+		t.Errorf("unexpected address: %s", dual.LocalAddr())
+	}
+	if received(client4, "10.0.0.1:53", dual) != dual {
+		t.Error("dual-stack socket did not receive IPv4 packet")
+	}
+	if received(client4, "[::ffff:10.0.0.1]:53", dual) != dual {
+		t.Error("dual-stack socket did not receive IPv4-mapped packet")
+	}
+	if received(client6, "[fd00::1]:53", dual) != dual {
+		t.Error("dual-stack socket did not receive IPv6 packet")
+	}
+	if _, err = h.ListenPacket("udp4", "0.0.0.0:53"); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// IPv4 and IPv6-only sockets share the port.
+	v4 := listenHost("udp4", ":54")
+	v6 := listenHost("udp6", ":54")
+	if received(client4, "10.0.0.1:54", v4, v6) != v4 {
+		t.Error("IPv4 packet not received by IPv4 socket")
+	}
+	if received(client6, "[fd00::1]:54", v4, v6) != v6 {
+		t.Error("IPv6 packet not received by IPv6 socket")
+	}
+
+	if _, err = client4.WriteTo([]byte("hello"), v6.LocalAddr()); !errors.Is(err, syscall.EAFNOSUPPORT) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNet_LinkLocal(t *testing.T) {
+	nt := NewNet(nil)
+	a := listen(t, nt, "[fe80::1%eth0]:53")
+	b := listen(t, nt, "[fe80::1%eth1]:53")
+	client := listen(t, nt, "[fe80::2%eth1]:123")
+
+	dst := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 53}
+	if _, err := client.WriteTo([]byte("hello"), dst); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	dst.Zone = "eth1"
+	if _, err := client.WriteTo([]byte("hello"), dst); err != nil {
+		t.Fatal(err)
+	}
+	if a.rx.len() != 0 || b.rx.len() != 1 {
+		t.Error("packet delivered to wrong zone")
+	}
+	if _, err := nt.ListenPacket("udp6", "[fe80::3]:53"); err == nil {
+		t.Error("link-local address without zone should fail")
+	}
+}
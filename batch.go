@@ -0,0 +1,110 @@
+package neo
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Message is a datagram of batch I/O, like ipv4.Message of
+// golang.org/x/net.
+type Message struct {
+	// Buffers are scattered or gathered payload of the datagram.
+	Buffers [][]byte
+	// OOB is the control message buffer, see ControlMessage.
+	OOB []byte
+	// Addr is the source address of read message and the destination
+	// address of written one. It may be nil for connected socket.
+	Addr net.Addr
+	// N is the number of payload bytes read or written.
+	N int
+	// NN is the number of control message bytes read or written.
+	NN int
+	// Flags are flags of read message, like MSG_TRUNC.
+	Flags int
+}
+
+// ReadBatch reads up to len(ms) packets, blocking until there is at least
+// one. Packets are read like by ReadMsgUDP, with payload scattered across
+// Buffers. It returns the number of read messages. Flags are ignored.
+func (c *PacketConn) ReadBatch(ms []Message, flags int) (int, error) {
+	if !c.ok() {
+		return 0, syscall.EINVAL
+	}
+	if len(ms) == 0 {
+		return 0, nil
+	}
+	s := scratchPool.Get().(*scratch)
+	defer s.free()
+	if cap(s.packets) < len(ms) {
+		s.packets = make([]packet, len(ms))
+	}
+	ps := s.packets[:len(ms)]
+	n, err := c.read(ps)
+	if err != nil {
+		return 0, err
+	}
+	for i, p := range ps[:n] {
+		m := &ms[i]
+		m.Addr = p.addr
+		m.N, m.NN, m.Flags = c.readMsg(p, m.Buffers, m.OOB)
+	}
+	return n, nil
+}
+
+// WriteBatch writes packets of ms like WriteMsgUDP, with payload gathered
+// from Buffers, and returns the number of written messages. Like sendmmsg,
+// it stops at the first failed message and returns its error only if no
+// messages were written. Flags are ignored.
+func (c *PacketConn) WriteBatch(ms []Message, flags int) (int, error) {
+	if !c.ok() {
+		return 0, syscall.EINVAL
+	}
+	raddr := c.RemoteAddr()
+	batch := make([]message, 0, len(ms))
+	var failed error
+	for i := range ms {
+		m := &ms[i]
+		a := m.Addr
+		if a == nil {
+			if a = raddr; a == nil {
+				failed = c.opError("write", nil, os.NewSyscallError("sendmmsg", errDestAddrReq))
+				break
+			}
+		}
+		var cm *ControlMessage
+		if len(m.OOB) > 0 {
+			var err error
+			if cm, err = ParseControlMessage(m.OOB); err != nil {
+				failed = c.opError("write", a, os.NewSyscallError("sendmmsg", err))
+				break
+			}
+		}
+		batch = append(batch, message{p: gather(m.Buffers), a: a, cm: cm})
+	}
+	n, err := c.writeBatch(batch)
+	for i := range ms[:n] {
+		ms[i].N = len(batch[i].p)
+		ms[i].NN = len(ms[i].OOB)
+	}
+	if n > 0 {
+		return n, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, failed
+}
+
+// gather returns concatenation of buffers, avoiding the copy for a single
+// buffer.
+func gather(buffers [][]byte) []byte {
+	if len(buffers) == 1 {
+		return buffers[0]
+	}
+	var p []byte
+	for _, b := range buffers {
+		p = append(p, b...)
+	}
+	return p
+}
@@ -0,0 +1,139 @@
+package neo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestPacketConn_Batch(t *testing.T) {
+	nt := NewNet(nil)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	out := make([]Message, 3)
+	for i := range out {
+		out[i] = Message{
+			Buffers: [][]byte{[]byte("hello "), []byte(fmt.Sprint(i))},
+			Addr:    left.LocalAddr(),
+		}
+	}
+	n, err := right.WriteBatch(out, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || out[2].N != 7 {
+		t.Fatalf("wrote %d messages, %d bytes", n, out[2].N)
+	}
+
+	if err = left.SetControlMessage(FlagDst, true); err != nil {
+		t.Fatal(err)
+	}
+	in := make([]Message, 4)
+	for i := range in {
+		in[i] = Message{
+			Buffers: [][]byte{make([]byte, 4), make([]byte, 4)},
+			OOB:     make([]byte, 64),
+		}
+	}
+	in[0].Buffers = [][]byte{make([]byte, 4)}
+	n, err = left.ReadBatch(in, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("read %d messages, expected 3", n)
+	}
+	if m := in[0]; m.N != 4 || m.Flags&msgTrunc == 0 {
+		t.Errorf("expected truncated message, got %d bytes, flags %x", m.N, m.Flags)
+	}
+	for i, m := range in[1:n] {
+		got := append(m.Buffers[0][:4:4], m.Buffers[1][:m.N-4]...)
+		if want := fmt.Sprintf("hello %d", i+1); string(got) != want {
+			t.Errorf("got %q, expected %q", got, want)
+		}
+		if m.Addr.String() != right.LocalAddr().String() {
+			t.Errorf("bad addr: %s", m.Addr)
+		}
+		cm, err := ParseControlMessage(m.OOB[:m.NN])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cm.Dst.Equal(net.IPv4(10, 0, 0, 1)) {
+			t.Errorf("bad dst: %s", cm.Dst)
+		}
+	}
+}
+
+func TestPacketConn_WriteBatchError(t *testing.T) {
+	nt := NewNet(nil)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	// Unconnected socket has no default destination.
+	ms := []Message{
+		{Buffers: [][]byte{[]byte("hello")}, Addr: left.LocalAddr()},
+		{Buffers: [][]byte{[]byte("hello")}},
+	}
+	n, err := right.WriteBatch(ms, 0)
+	if err != nil || n != 1 {
+		t.Fatalf("wrote %d messages: %v", n, err)
+	}
+	if n, err = right.WriteBatch(ms[1:], 0); !errors.Is(err, errDestAddrReq) || n != 0 {
+		t.Errorf("wrote %d messages: %v", n, err)
+	}
+	if left.rx.len() != 1 {
+		t.Errorf("got %d packets, expected 1", left.rx.len())
+	}
+}
+
+func BenchmarkPacketConn_WriteTo(b *testing.B) {
+	left, right := benchmarkPair(b, NewNet(nil), 1)
+	msg := bytes.Repeat([]byte{1}, 1200)
+	buf := make([]byte, 1500)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchmarkBatch {
+		for j := 0; j < benchmarkBatch; j++ {
+			if _, err := right.WriteTo(msg, left.LocalAddr()); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for j := 0; j < benchmarkBatch; j++ {
+			if _, _, err := left.ReadFrom(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPacketConn_WriteBatch(b *testing.B) {
+	left, right := benchmarkPair(b, NewNet(nil), 1)
+	msg := bytes.Repeat([]byte{1}, 1200)
+	out := make([]Message, benchmarkBatch)
+	in := make([]Message, benchmarkBatch)
+	for i := range out {
+		out[i] = Message{Buffers: [][]byte{msg}, Addr: left.LocalAddr()}
+		in[i] = Message{Buffers: [][]byte{make([]byte, 1500)}}
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchmarkBatch {
+		if _, err := right.WriteBatch(out, 0); err != nil {
+			b.Fatal(err)
+		}
+		for read := 0; read < benchmarkBatch; {
+			n, err := left.ReadBatch(in, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			read += n
+		}
+	}
+}
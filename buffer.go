@@ -0,0 +1,136 @@
+package neo
+
+import "sync"
+
+// defaultBufferBytes is the default socket buffer size, same as
+// net.core.rmem_default of Linux.
+const defaultBufferBytes = 212992
+
+// BufferSize limits a socket buffer. Zero fields mean no limit.
+type BufferSize struct {
+	// Bytes limits total payload size of buffered packets.
+	Bytes int
+	// Packets limits number of buffered packets.
+	Packets int
+}
+
+// fits reports whether a packet of size n fits into buffer that already holds
+// the given number of packets and bytes.
+func (s BufferSize) fits(packets, bytes, n int) bool {
+	if s.Packets > 0 && packets >= s.Packets {
+		return false
+	}
+	if s.Bytes > 0 && bytes+n > s.Bytes {
+		return false
+	}
+	return true
+}
+
+// buffer is a kernel-like socket receive buffer that drops packets on
+// overflow instead of blocking writers.
+//
+// Packets are kept in a ring that grows to the largest number of buffered
+// packets and is reused afterwards, so steady traffic does not allocate.
+type buffer struct {
+	mux  sync.Mutex
+	size BufferSize
+	// ring holds count packets starting from head, wrapping around.
+	ring  []packet
+	head  int
+	count int
+	bytes int
+
+	// ready is signaled when buffer becomes non-empty.
+	ready chan struct{}
+}
+
+func newBuffer(size BufferSize) *buffer {
+	return &buffer{
+		size:  size,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (b *buffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push appends p to the buffer and returns the number of buffered packets.
+// It returns false if p does not fit and was dropped.
+func (b *buffer) push(p packet) (int, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if !b.size.fits(b.count, b.bytes, len(p.buf)) {
+		return b.count, false
+	}
+	if b.count == len(b.ring) {
+		b.grow()
+	}
+	b.ring[(b.head+b.count)%len(b.ring)] = p
+	b.count++
+	b.bytes += len(p.buf)
+	b.signal()
+	return b.count, true
+}
+
+// grow doubles capacity of the ring, unwrapping buffered packets.
+func (b *buffer) grow() {
+	ring := make([]packet, 2*len(b.ring)+8)
+	n := copy(ring, b.ring[b.head:])
+	copy(ring[n:], b.ring[:b.head])
+	b.ring = ring
+	b.head = 0
+}
+
+// pop removes the oldest packet from the buffer. It returns false if the
+// buffer is empty.
+func (b *buffer) pop() (packet, bool) {
+	var ps [1]packet
+	if b.popBatch(ps[:]) == 0 {
+		return packet{}, false
+	}
+	return ps[0], true
+}
+
+// popBatch removes up to len(ps) oldest packets from the buffer into ps and
+// returns their number.
+func (b *buffer) popBatch(ps []packet) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	n := len(ps)
+	if n > b.count {
+		n = b.count
+	}
+	for i := range ps[:n] {
+		ps[i] = b.ring[b.head]
+		b.ring[b.head] = packet{}
+		b.bytes -= len(ps[i].buf)
+		b.head = (b.head + 1) % len(b.ring)
+	}
+	b.count -= n
+	if b.count > 0 {
+		// Wake up the next reader.
+		b.signal()
+	}
+	return n
+}
+
+// len returns number of buffered packets.
+func (b *buffer) len() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.count
+}
+
+func (b *buffer) resize(size BufferSize) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.size = size
+}
@@ -0,0 +1,147 @@
+package neo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketConn_ReadBuffer(t *testing.T) {
+	nt := NewNet(nil)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	if err := left.SetReadBufferSize(BufferSize{Packets: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := left.rx.len(); n != 2 {
+		t.Errorf("got %d buffered packets, expected 2", n)
+	}
+	if drops := left.Stats().Drops[DropReadBuffer]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	// Free the buffer and limit it in bytes.
+	left.rx.pop()
+	left.rx.pop()
+	if err := left.SetReadBuffer(8); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "bye", "!"} {
+		if _, err := right.WriteTo([]byte(msg), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := left.rx.len(); n != 2 {
+		t.Errorf("got %d buffered packets, expected 2", n)
+	}
+	if drops := left.Stats().Drops[DropReadBuffer]; drops != 2 {
+		t.Errorf("got %d drops, expected 2", drops)
+	}
+}
+
+func TestPacketConn_WriteBuffer(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	nt.SetDefaultLink(Link{Latency: FixedLatency(time.Second)})
+
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	if err := right.SetWriteBuffer(10); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if drops := right.Stats().Drops[DropWriteBuffer]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	// Delivered packets free the send buffer.
+	sim.Travel(time.Second)
+	if n := left.rx.len(); n != 2 {
+		t.Errorf("got %d delivered packets, expected 2", n)
+	}
+	if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if drops := right.Stats().Drops[DropWriteBuffer]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+}
+
+func TestPacketConn_ReadBlocked(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	nt.SetDefaultLink(Link{Latency: FixedLatency(time.Second)})
+
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := left.ReadFrom(make([]byte, 1024))
+		read <- err
+	}()
+	if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	sim.Travel(time.Second)
+
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out")
+	}
+}
+
+func TestBuffer_Ring(t *testing.T) {
+	b := newBuffer(BufferSize{})
+	next, want := 0, 0
+	push := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, ok := b.push(packet{buf: []byte{byte(next)}}); !ok {
+				t.Fatal("dropped")
+			}
+			next++
+		}
+	}
+	pop := func(n int) {
+		ps := make([]packet, n)
+		if got := b.popBatch(ps); got != n {
+			t.Fatalf("popped %d packets, expected %d", got, n)
+		}
+		for _, p := range ps {
+			if int(p.buf[0]) != want {
+				t.Fatalf("got packet %d, expected %d", p.buf[0], want)
+			}
+			want++
+		}
+	}
+
+	// Wrap around the ring and grow it while wrapped.
+	push(6)
+	pop(4)
+	push(5)
+	pop(3)
+	push(20)
+	if b.len() != 24 || b.bytes != 24 {
+		t.Fatalf("got %d packets, %d bytes", b.len(), b.bytes)
+	}
+	pop(24)
+	if _, ok := b.pop(); ok {
+		t.Error("buffer is not empty")
+	}
+}
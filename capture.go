@@ -0,0 +1,213 @@
+package neo
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Datagram is a packet sent over the virtual network.
+type Datagram struct {
+	Src     *net.UDPAddr
+	Dst     *net.UDPAddr
+	Payload []byte
+	// Time is the virtual time when datagram was sent.
+	Time time.Time
+}
+
+// CaptureConfig contains options for capturing traffic.
+type CaptureConfig struct {
+	// Hosts limits capture to datagrams sent from or to any of the given
+	// addresses. Empty Hosts captures datagrams of all hosts.
+	Hosts []net.IP
+	// Filter, if not nil, selects datagrams to capture, e.g. ones of a
+	// single link.
+	Filter func(d Datagram) bool
+}
+
+// pcap constants, see https://www.tcpdump.org/manpages/pcap-savefile.5.html.
+const (
+	pcapMagicNano = 0xa1b23c4d
+	pcapSnapLen   = 65535
+	// linkTypeRaw is LINKTYPE_RAW, records start with IPv4 or IPv6 header.
+	linkTypeRaw = 101
+)
+
+// Capture writes datagrams sent over Net in pcap format with nanosecond
+// timestamps, so traffic can be inspected with tools like Wireshark. Each
+// record has synthesized IP and UDP headers, and the virtual time when the
+// datagram was sent, before link model applies.
+type Capture struct {
+	net *Net
+	cfg CaptureConfig
+
+	mux sync.Mutex
+	w   io.Writer
+	err error
+	id  uint16
+}
+
+// Capture starts capturing traffic to w until Close is called.
+func (n *Net) Capture(w io.Writer, cfg CaptureConfig) (*Capture, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagicNano)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+
+	c := &Capture{net: n, cfg: cfg, w: w}
+	n.mux.Lock()
+	n.captures = append(n.captures, c)
+	n.mux.Unlock()
+	return c, nil
+}
+
+// Close stops capturing and returns the first error of writing records, if
+// any. It does not close the underlying writer.
+func (c *Capture) Close() error {
+	n := c.net
+	n.mux.Lock()
+	for i, o := range n.captures {
+		if o == c {
+			n.captures = append(n.captures[:i], n.captures[i+1:]...)
+			break
+		}
+	}
+	n.mux.Unlock()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.err
+}
+
+func (c *Capture) match(d Datagram) bool {
+	if len(c.cfg.Hosts) > 0 {
+		var found bool
+		for _, ip := range c.cfg.Hosts {
+			if ip.Equal(d.Src.IP) || ip.Equal(d.Dst.IP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return c.cfg.Filter == nil || c.cfg.Filter(d)
+}
+
+// record writes d if it matches capture configuration.
+func (c *Capture) record(d Datagram) {
+	if !c.match(d) {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.id++
+	pkt := ipPacket(d, c.id)
+
+	size := len(pkt)
+	if size > pcapSnapLen {
+		size = pcapSnapLen
+	}
+	var hdr [16]byte
+	ts := d.Time.UnixNano()
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(ts/int64(time.Second)))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(ts%int64(time.Second)))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(size))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(pkt)))
+	if _, err := c.w.Write(hdr[:]); err != nil {
+		c.err = err
+		return
+	}
+	if _, err := c.w.Write(pkt[:size]); err != nil {
+		c.err = err
+	}
+}
+
+// ipPacket synthesizes IPv4 or IPv6 packet with UDP header for d.
+func ipPacket(d Datagram, id uint16) []byte {
+	const (
+		protoUDP = 17
+		ttl      = 64
+	)
+	udpLen := udpHeaderLen + len(d.Payload)
+
+	var ip, pseudo []byte
+	if src, dst := d.Src.IP.To4(), d.Dst.IP.To4(); src != nil && dst != nil {
+		ip = make([]byte, ipv4HeaderLen)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+udpLen))
+		binary.BigEndian.PutUint16(ip[4:], id)
+		ip[8] = ttl
+		ip[9] = protoUDP
+		copy(ip[12:], src)
+		copy(ip[16:], dst)
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:], src)
+		copy(pseudo[4:], dst)
+		pseudo[9] = protoUDP
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(udpLen))
+	} else {
+		ip = make([]byte, ipv6HeaderLen)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+		ip[6] = protoUDP
+		ip[7] = ttl
+		copy(ip[8:], d.Src.IP.To16())
+		copy(ip[24:], d.Dst.IP.To16())
+
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:], d.Src.IP.To16())
+		copy(pseudo[16:], d.Dst.IP.To16())
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(udpLen))
+		pseudo[39] = protoUDP
+	}
+
+	pkt := make([]byte, len(ip)+udpLen)
+	copy(pkt, ip)
+	udp := pkt[len(ip):]
+	binary.BigEndian.PutUint16(udp[0:], uint16(d.Src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(d.Dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[udpHeaderLen:], d.Payload)
+	sum := checksum(sumWords(0, pseudo), udp)
+	if sum == 0 {
+		// Zero means no checksum, so it is transmitted as all ones.
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return pkt
+}
+
+// sumWords adds b as big-endian 16-bit words to one's complement sum.
+func sumWords(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// checksum returns Internet checksum of b with initial sum.
+func checksum(sum uint32, b []byte) uint16 {
+	sum = sumWords(sum, b)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,119 @@
+package neo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+type pcapRecord struct {
+	time time.Time
+	data []byte
+}
+
+func readPcap(t *testing.T, b []byte) []pcapRecord {
+	t.Helper()
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != pcapMagicNano {
+		t.Fatal("bad pcap header")
+	}
+	if binary.LittleEndian.Uint32(b[20:]) != linkTypeRaw {
+		t.Fatal("bad link type")
+	}
+	b = b[24:]
+	var records []pcapRecord
+	for len(b) > 0 {
+		sec := binary.LittleEndian.Uint32(b[0:])
+		nsec := binary.LittleEndian.Uint32(b[4:])
+		size := binary.LittleEndian.Uint32(b[8:])
+		records = append(records, pcapRecord{
+			time: time.Unix(int64(sec), int64(nsec)),
+			data: b[16 : 16+size],
+		})
+		b = b[16+size:]
+	}
+	return records
+}
+
+func TestNet_Capture(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+
+	var all, filtered bytes.Buffer
+	captureAll, err := nt.Capture(&all, CaptureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureHost, err := nt.Capture(&filtered, CaptureConfig{
+		Hosts: []net.IP{net.ParseIP("fd00::1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a4 := listen(t, nt, "10.0.0.1:1000")
+	b4 := listen(t, nt, "10.0.0.2:2000")
+	a6 := listen(t, nt, "[fd00::1]:1000")
+	b6 := listen(t, nt, "[fd00::2]:2000")
+
+	if _, err = a4.WriteTo([]byte("hello"), b4.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	sim.Travel(time.Second)
+	if _, err = a6.WriteTo([]byte("hello!"), b6.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err = captureAll.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = captureHost.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closed capture records nothing.
+	if _, err = a4.WriteTo([]byte("hello"), b4.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readPcap(t, all.Bytes())
+	if len(records) != 2 {
+		t.Fatalf("got %d records, expected 2", len(records))
+	}
+	if len(readPcap(t, filtered.Bytes())) != 1 {
+		t.Error("host filter not applied")
+	}
+
+	v4 := records[0]
+	if !v4.time.Equal(now) {
+		t.Errorf("unexpected time: %s", v4.time)
+	}
+	if v4.data[0] != 0x45 || len(v4.data) != 20+8+5 {
+		t.Fatal("bad IPv4 packet")
+	}
+	if checksum(0, v4.data[:20]) != 0 {
+		t.Error("bad IPv4 header checksum")
+	}
+	if !net.IP(v4.data[12:16]).Equal(net.IPv4(10, 0, 0, 1)) || binary.BigEndian.Uint16(v4.data[22:]) != 2000 {
+		t.Error("bad IPv4 addresses")
+	}
+	pseudo := append(append([]byte{}, v4.data[12:20]...), 0, 17, 0, 13)
+	if checksum(sumWords(0, pseudo), v4.data[20:]) != 0 {
+		t.Error("bad UDP checksum")
+	}
+	if string(v4.data[28:]) != "hello" {
+		t.Error("bad payload")
+	}
+
+	v6 := records[1]
+	if !v6.time.Equal(now.Add(time.Second)) {
+		t.Errorf("unexpected time: %s", v6.time)
+	}
+	if v6.data[0]>>4 != 6 || len(v6.data) != 40+8+6 {
+		t.Fatal("bad IPv6 packet")
+	}
+	pseudo = append(append([]byte{}, v6.data[8:40]...), 0, 0, 0, 14, 0, 0, 0, 17)
+	if checksum(sumWords(0, pseudo), v6.data[40:]) != 0 {
+		t.Error("bad UDP checksum")
+	}
+}
@@ -0,0 +1,216 @@
+package neo
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// Levels and types of control messages, as on Linux.
+const (
+	solIP        = 0
+	ipTTL        = 2
+	ipPktinfo    = 8
+	solIPv6      = 41
+	ipv6Pktinfo  = 50
+	ipv6Hoplimit = 52
+)
+
+// defaultTTL is the default TTL and hop limit of sent packets.
+const defaultTTL = 64
+
+// maxTTL is the maximum TTL and hop limit of sent packets.
+const maxTTL = 255
+
+// ControlFlags select control messages received with packets.
+type ControlFlags uint
+
+// Control message flags.
+const (
+	// FlagTTL enables receiving of TTL or hop limit.
+	FlagTTL ControlFlags = 1 << iota
+	// FlagDst enables receiving of destination address.
+	FlagDst
+)
+
+// ControlMessage is per packet IP-level socket options, like ControlMessage
+// of golang.org/x/net/ipv4 and golang.org/x/net/ipv6.
+//
+// Control messages are marshaled like IP_TTL and IP_PKTINFO or IPV6_HOPLIMIT
+// and IPV6_PKTINFO messages of Linux, so they can be parsed by
+// golang.org/x/net packages on Linux.
+type ControlMessage struct {
+	// TTL is time-to-live or hop limit of packet. When sending, zero means
+	// the default one and values above 255 are rejected with EINVAL.
+	TTL int
+	// Src is source address of sent packet, nil means the address selected
+	// by the host.
+	Src net.IP
+	// Dst is destination address of received packet.
+	Dst net.IP
+}
+
+// cmsgAlign aligns n like CMSG_ALIGN.
+func cmsgAlign(n int) int {
+	const align = strconv.IntSize / 8
+	return (n + align - 1) &^ (align - 1)
+}
+
+// cmsgHeaderLen is the aligned length of cmsghdr: size_t length, int level
+// and int type.
+var cmsgHeaderLen = cmsgAlign(strconv.IntSize/8 + 4 + 4)
+
+// appendCmsg appends control message with the given level, type and data.
+func appendCmsg(b []byte, level, typ int, data []byte) []byte {
+	h := make([]byte, cmsgHeaderLen+cmsgAlign(len(data)))
+	size := uint64(cmsgHeaderLen + len(data))
+	if strconv.IntSize == 64 {
+		nativeEndian.PutUint64(h, size)
+	} else {
+		nativeEndian.PutUint32(h, uint32(size))
+	}
+	nativeEndian.PutUint32(h[strconv.IntSize/8:], uint32(level))
+	nativeEndian.PutUint32(h[strconv.IntSize/8+4:], uint32(typ))
+	copy(h[cmsgHeaderLen:], data)
+	return append(b, h...)
+}
+
+// Marshal returns the binary encoding of cm. Messages are encoded for IPv6 if
+// Src or Dst is IPv6 address and for IPv4 otherwise.
+func (cm *ControlMessage) Marshal() []byte {
+	if cm == nil {
+		return nil
+	}
+	ip := cm.Dst
+	if ip == nil {
+		ip = cm.Src
+	}
+	if ip != nil && ip.To4() == nil {
+		return cm.marshal(familyIPv6)
+	}
+	return cm.marshal(familyIPv4)
+}
+
+func (cm *ControlMessage) marshal(f family) []byte {
+	var b []byte
+	ttl := make([]byte, 4)
+	nativeEndian.PutUint32(ttl, uint32(cm.TTL))
+	if f == familyIPv4 {
+		if cm.TTL > 0 {
+			b = appendCmsg(b, solIP, ipTTL, ttl)
+		}
+		if cm.Src != nil || cm.Dst != nil {
+			// struct in_pktinfo: ifindex, spec_dst and addr.
+			info := make([]byte, 12)
+			copy(info[4:8], cm.Src.To4())
+			copy(info[8:12], cm.Dst.To4())
+			b = appendCmsg(b, solIP, ipPktinfo, info)
+		}
+		return b
+	}
+	if cm.TTL > 0 {
+		b = appendCmsg(b, solIPv6, ipv6Hoplimit, ttl)
+	}
+	if ip := cm.Dst; ip != nil || cm.Src != nil {
+		if ip == nil {
+			ip = cm.Src
+		}
+		// struct in6_pktinfo: addr and ifindex.
+		info := make([]byte, 20)
+		copy(info, ip.To16())
+		b = appendCmsg(b, solIPv6, ipv6Pktinfo, info)
+	}
+	return b
+}
+
+// ParseControlMessage parses control messages encoded by Marshal or received
+// by ReadMsgUDP. Unknown messages are ignored.
+//
+// The address of IPV6_PKTINFO message is returned as both Src and Dst,
+// because it is the source address of sent packet and the destination address
+// of received one.
+func ParseControlMessage(b []byte) (*ControlMessage, error) {
+	cm := &ControlMessage{}
+	for len(b) > 0 {
+		level, typ, data, n, err := parseCmsg(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := cm.parse(level, typ, data); err != nil {
+			return nil, err
+		}
+		if n < len(b) {
+			b = b[n:]
+		} else {
+			b = nil
+		}
+	}
+	return cm, nil
+}
+
+// parseCmsg parses header of the first control message of b and returns its
+// level, type, data and aligned length.
+func parseCmsg(b []byte) (level, typ int, data []byte, n int, err error) {
+	if len(b) < cmsgHeaderLen {
+		return 0, 0, nil, 0, syscall.EINVAL
+	}
+	var size int
+	if strconv.IntSize == 64 {
+		size = int(nativeEndian.Uint64(b))
+	} else {
+		size = int(nativeEndian.Uint32(b))
+	}
+	if size < cmsgHeaderLen || size > len(b) {
+		return 0, 0, nil, 0, syscall.EINVAL
+	}
+	level = int(nativeEndian.Uint32(b[strconv.IntSize/8:]))
+	typ = int(nativeEndian.Uint32(b[strconv.IntSize/8+4:]))
+	return level, typ, b[cmsgHeaderLen:size], cmsgAlign(size), nil
+}
+
+// parse sets fields of cm from data of control message with the given level
+// and type.
+func (cm *ControlMessage) parse(level, typ int, data []byte) error {
+	switch {
+	case (level == solIP && typ == ipTTL) || (level == solIPv6 && typ == ipv6Hoplimit):
+		if len(data) < 4 {
+			return syscall.EINVAL
+		}
+		cm.TTL = int(nativeEndian.Uint32(data))
+	case level == solIP && typ == ipPktinfo:
+		if len(data) < 12 {
+			return syscall.EINVAL
+		}
+		if spec := net.IP(data[4:8]); !spec.Equal(net.IPv4zero) {
+			cm.Src = net.IP(append([]byte{}, spec...))
+		}
+		cm.Dst = net.IP(append([]byte{}, data[8:12]...))
+	case level == solIPv6 && typ == ipv6Pktinfo:
+		if len(data) < 20 {
+			return syscall.EINVAL
+		}
+		cm.Src = net.IP(append([]byte{}, data[:16]...))
+		cm.Dst = cm.Src
+	}
+	return nil
+}
+
+// SetControlMessage sets whether control messages selected by cf are
+// received by ReadMsgUDP.
+func (c *PacketConn) SetControlMessage(cf ControlFlags, on bool) error {
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if on {
+		c.cmsg |= cf
+	} else {
+		c.cmsg &^= cf
+	}
+	return nil
+}
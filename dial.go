@@ -0,0 +1,130 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// DialUDP returns UDP socket of the host connected to raddr, like
+// net.DialUDP. If laddr is nil or has no IP, the source address is selected
+// by raddr, and zero port is replaced by an ephemeral one.
+//
+// Connected socket receives packets only from raddr and can Read and Write
+// without addresses. If enabled by Net.SetPortUnreachable, packets sent to
+// the port nobody listens on make the next Read or Write fail with
+// ECONNREFUSED.
+func (h *Host) DialUDP(network string, laddr, raddr *net.UDPAddr) (*PacketConn, error) {
+	n := h.net
+	local, remote, err := n.resolveDial(network, laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	return h.dialUnlocked(network, local, remote)
+}
+
+// Dial connects to the address on the named network, like net.Dial. Only
+// "udp", "udp4" and "udp6" networks are supported.
+func (h *Host) Dial(network, address string) (net.Conn, error) {
+	raddr, err := h.net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	c, err := h.DialUDP(network, nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialUDP is like Host.DialUDP on the host that owns IP of laddr. If no
+// host owns the address, a single-address host is added. If laddr is nil or
+// has unspecified IP, the socket belongs to the default host, see
+// Net.SetDefaultHost.
+func (n *Net) DialUDP(network string, laddr, raddr *net.UDPAddr) (*PacketConn, error) {
+	local, remote, err := n.resolveDial(network, laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	var h *Host
+	if local.IP.IsUnspecified() || local.IP.IsMulticast() {
+		h = n.defaultHostUnlocked()
+	} else {
+		h = n.hostUnlocked(local.IP, local.Zone)
+	}
+	return h.dialUnlocked(network, local, remote)
+}
+
+// Dial is like Host.Dial on the default host, see Net.SetDefaultHost.
+func (n *Net) Dial(network, address string) (net.Conn, error) {
+	raddr, err := n.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	c, err := n.DialUDP(network, nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// resolveDial resolves local and remote addresses of connected socket.
+func (n *Net) resolveDial(network string, laddr, raddr *net.UDPAddr) (local, remote *net.UDPAddr, err error) {
+	if raddr == nil {
+		return nil, nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Err: errors.New("missing address")}
+	}
+	if remote, err = n.ResolveUDPAddr(network, raddr.String()); err != nil {
+		return nil, nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: err}
+	}
+	if remote.IP == nil || remote.IP.IsUnspecified() || (isLinkLocal(remote.IP) && remote.Zone == "") {
+		return nil, nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: syscall.EINVAL}
+	}
+	local = &net.UDPAddr{}
+	if laddr != nil {
+		local = laddr
+	}
+	if local, err = n.resolveListen(network, local.String()); err != nil {
+		return nil, nil, &net.OpError{Op: "dial", Net: network, Source: laddr, Addr: raddr, Err: err}
+	}
+	return local, remote, nil
+}
+
+// dialUnlocked binds socket of h to local address and connects it to remote.
+func (h *Host) dialUnlocked(network string, local, remote *net.UDPAddr) (*PacketConn, error) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: network, Source: local, Addr: remote, Err: err}
+	}
+	if h.net.routers > 0 {
+		if _, ok := h.nextHopUnlocked(remote.IP); !ok {
+			return nil, opError(os.NewSyscallError("connect", errNetUnreach))
+		}
+	}
+	if local.IP.IsUnspecified() {
+		// Like connect(2), bind to the address selected by destination.
+		src := h.sourceAddrUnlocked(remote)
+		if ipFamily(src.IP) != ipFamily(remote.IP) {
+			return nil, opError(os.NewSyscallError("connect", errNetUnreach))
+		}
+		local = &net.UDPAddr{IP: src.IP, Port: local.Port, Zone: src.zone}
+	}
+	if !socketFamily(network, local.IP).has(ipFamily(remote.IP)) {
+		return nil, opError(os.NewSyscallError("connect", syscall.EAFNOSUPPORT))
+	}
+	c, err := h.listenUnlocked(ListenConfig{}, network, local)
+	if err != nil {
+		return nil, opError(err)
+	}
+	c.mux.Lock()
+	c.raddr = remote
+	c.mux.Unlock()
+	return c, nil
+}
@@ -0,0 +1,116 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestHost_Dial(t *testing.T) {
+	nt := NewNet(nil)
+	h, err := nt.AddHost("10.0.0.1/24", "192.168.1.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := listen(t, nt, "192.168.1.2:53")
+	other := listen(t, nt, "192.168.1.3:53")
+
+	conn, err := h.Dial("udp", "192.168.1.2:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if !local.IP.Equal(net.IPv4(192, 168, 1, 1)) || local.Port == 0 {
+		t.Errorf("bad local address %s", local)
+	}
+	if conn.RemoteAddr().String() != "192.168.1.2:53" {
+		t.Errorf("bad remote address %s", conn.RemoteAddr())
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	_, addr, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != local.String() {
+		t.Errorf("got packet from %s", addr)
+	}
+
+	// Packets from other sources are filtered.
+	if _, err := other.WriteTo([]byte("spoof"), local); err != nil {
+		t.Fatal(err)
+	}
+	if drops := other.Stats().Drops[DropNoListener]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+	if _, err := server.WriteTo([]byte("pong"), local); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" {
+		t.Errorf("got %q", buf[:n])
+	}
+
+	c := conn.(*PacketConn)
+	if _, err := c.WriteTo([]byte("ping"), server.LocalAddr()); !errors.Is(err, net.ErrWriteToConnected) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNet_DialUDP(t *testing.T) {
+	nt := NewNet(nil)
+	nt.SetPortUnreachable(true)
+	server := listen(t, nt, "10.0.0.2:53")
+	laddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}
+	listen(t, nt, "10.0.0.1:5000")
+
+	// Port is in use by unconnected socket.
+	if _, err := nt.DialUDP("udp", laddr, server.LocalAddr().(*net.UDPAddr)); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Socket without local address belongs to the default host.
+	c, err := nt.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LocalAddr().(*net.UDPAddr).IP.String() != "127.0.0.1" {
+		t.Errorf("bad local address %s", c.LocalAddr())
+	}
+	nc, err := nt.Dial("udp", "10.0.0.2:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nc.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.rx.len(); n != 2 {
+		t.Errorf("got %d packets, expected 2", n)
+	}
+	if _, err := nt.DialUDP("udp4", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)}, &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 53}); err == nil {
+		t.Error("dialed address of other family")
+	}
+
+	conn, err := nt.DialUDP("udp", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 54})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// Port unreachable is reported by the next write.
+	if _, err := conn.Write([]byte("ping")); !errors.Is(err, errConnRefused) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Errorf("error is not cleared: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build mips || mips64 || ppc64 || s390x
+
+package neo
+
+import "encoding/binary"
+
+// nativeEndian is the byte order of the host, used for control messages.
+var nativeEndian = binary.BigEndian
@@ -0,0 +1,8 @@
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm
+
+package neo
+
+import "encoding/binary"
+
+// nativeEndian is the byte order of the host, used for control messages.
+var nativeEndian = binary.LittleEndian
@@ -0,0 +1,18 @@
+//go:build !plan9
+// +build !plan9
+
+package neo
+
+import "syscall"
+
+// Errors of socket operations, see errno_plan9.go for platforms that lack
+// them.
+const (
+	errAddrInUse    = syscall.EADDRINUSE
+	errAddrNotAvail = syscall.EADDRNOTAVAIL
+	errConnRefused  = syscall.ECONNREFUSED
+	errDestAddrReq  = syscall.EDESTADDRREQ
+	errHostUnreach  = syscall.EHOSTUNREACH
+	errMsgSize      = syscall.EMSGSIZE
+	errNetUnreach   = syscall.ENETUNREACH
+)
@@ -0,0 +1,15 @@
+package neo
+
+import "syscall"
+
+// Errors of socket operations. Plan 9 has no such errno values, so errors
+// with messages of Linux are used.
+var (
+	errAddrInUse    = syscall.NewError("address already in use")
+	errAddrNotAvail = syscall.NewError("cannot assign requested address")
+	errConnRefused  = syscall.NewError("connection refused")
+	errDestAddrReq  = syscall.NewError("destination address required")
+	errHostUnreach  = syscall.NewError("no route to host")
+	errMsgSize      = syscall.NewError("message too long")
+	errNetUnreach   = syscall.NewError("network is unreachable")
+)
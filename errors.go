@@ -0,0 +1,21 @@
+package neo
+
+import (
+	"net"
+	"os"
+)
+
+// ErrDeadline is the error of I/O operation that exceeded deadline, wrapped
+// into *net.OpError like in the net package.
+var ErrDeadline = os.ErrDeadlineExceeded
+
+// opError wraps err of operation op with the socket addresses, like the net
+// package does. The addr is the remote address, if any.
+func (c *PacketConn) opError(op string, addr net.Addr, err error) error {
+	if addr == nil {
+		c.mux.Lock()
+		addr = c.raddr
+		c.mux.Unlock()
+	}
+	return &net.OpError{Op: op, Net: c.network, Source: c.addr, Addr: addr, Err: err}
+}
@@ -0,0 +1,63 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPacketConn_Errors(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	if err := left.SetReadDeadline(now); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := left.ReadFrom(make([]byte, 1024))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("not a timeout: %v", err)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("not deadline exceeded: %v", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "read" || opErr.Source != left.LocalAddr() {
+		t.Errorf("bad op error: %#v", err)
+	}
+
+	nt.Partition([]net.IP{net.IPv4(10, 0, 0, 1)}, []net.IP{net.IPv4(10, 0, 0, 2)})
+	nt.SetPartitionReject(true)
+	_, err = right.WriteTo([]byte("hello"), left.LocalAddr())
+	if !errors.As(err, &opErr) || opErr.Op != "write" || opErr.Addr != left.LocalAddr() {
+		t.Errorf("bad op error: %#v", err)
+	}
+	var sysErr *os.SyscallError
+	if !errors.As(err, &sysErr) || sysErr.Syscall != "sendto" || !errors.Is(err, errHostUnreach) {
+		t.Errorf("bad syscall error: %v", err)
+	}
+
+	if err := left.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		left.Close(),
+		left.SetDeadline(time.Time{}),
+		left.SetReadBuffer(1024),
+		func() error { _, _, err := left.ReadFrom(nil); return err }(),
+		func() error { _, err := left.WriteTo(nil, right.LocalAddr()); return err }(),
+	} {
+		if !errors.Is(err, net.ErrClosed) || !errors.As(err, &opErr) {
+			t.Errorf("bad error of closed socket: %v", err)
+		}
+	}
+
+	if _, err := nt.ListenPacket("udp", "10.0.0.2:123"); !errors.As(err, &opErr) || opErr.Op != "listen" {
+		t.Errorf("bad listen error: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package neo
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Action is a verdict of firewall rule.
+type Action int
+
+// Firewall actions.
+const (
+	// Accept lets packet pass.
+	Accept Action = iota
+	// Deny silently drops packet.
+	Deny
+)
+
+func (a Action) String() string {
+	switch a {
+	case Accept:
+		return "accept"
+	case Deny:
+		return "deny"
+	default:
+		return "unknown"
+	}
+}
+
+// Direction is a direction of packet relative to the host.
+type Direction int
+
+// Packet directions.
+const (
+	// Inbound packets are received by the host.
+	Inbound Direction = iota + 1
+	// Outbound packets are sent by the host.
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	default:
+		return "any"
+	}
+}
+
+// PortRange is an inclusive range of ports. The zero value matches any port,
+// zero Max means range of the single Min port.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+func (r PortRange) contains(port int) bool {
+	if r.Min == 0 && r.Max == 0 {
+		return true
+	}
+	max := r.Max
+	if max == 0 {
+		max = r.Min
+	}
+	return port >= r.Min && port <= max
+}
+
+// Rule is a firewall rule. Zero fields match any packet.
+type Rule struct {
+	Action    Action
+	Direction Direction
+
+	// Src and Dst are networks of packet source and destination.
+	Src *net.IPNet
+	Dst *net.IPNet
+	// SrcPorts and DstPorts are ports of packet source and destination.
+	SrcPorts PortRange
+	DstPorts PortRange
+
+	// Established matches only packets of established flows, i.e. flows
+	// that had a packet accepted in any direction within the flow timeout.
+	Established bool
+}
+
+// defaultFlowTimeout is the default idle timeout of tracked flows, like
+// nf_conntrack_udp_timeout of Linux.
+const defaultFlowTimeout = 30 * time.Second
+
+// Firewall is a stateful packet filter of a host.
+//
+// Packets are matched against Rules in order and the first matching rule
+// decides. Packets that match no rule are handled by Policy.
+//
+// Outbound packets denied by firewall fail WriteTo with EPERM, like on Linux.
+// Inbound packets are silently dropped.
+type Firewall struct {
+	Rules  []Rule
+	Policy Action
+
+	// FlowTimeout is the idle timeout of established flows on the bound
+	// clock, zero means 30 seconds.
+	FlowTimeout time.Duration
+}
+
+// flow is a tracked pair of local and remote addresses.
+type flow struct {
+	local  netip.AddrPort
+	remote netip.AddrPort
+}
+
+// firewall is a firewall with state of tracked flows, guarded by mux of Net.
+type firewall struct {
+	Firewall
+	// flows are expiration times of established flows.
+	flows map[flow]time.Time
+}
+
+// SetFirewall sets firewall of the host, nil removes it. Setting firewall
+// resets its tracked flows.
+func (h *Host) SetFirewall(f *Firewall) {
+	h.net.mux.Lock()
+	defer h.net.mux.Unlock()
+
+	h.setFirewallUnlocked(f)
+}
+
+// SetFirewall sets firewall of the host owning ip, nil removes it.
+func (n *Net) SetFirewall(ip net.IP, f *Firewall) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.hostUnlocked(ip, "").setFirewallUnlocked(f)
+}
+
+func (h *Host) setFirewallUnlocked(f *Firewall) {
+	if f == nil {
+		h.firewall = nil
+		return
+	}
+	fw := &firewall{Firewall: *f, flows: map[flow]time.Time{}}
+	fw.Rules = append([]Rule(nil), f.Rules...)
+	if fw.FlowTimeout <= 0 {
+		fw.FlowTimeout = defaultFlowTimeout
+	}
+	h.firewall = fw
+}
+
+// allowUnlocked reports whether host accepts packet from src to dst at now in
+// the given direction, tracking flow of accepted packet.
+func (h *Host) allowUnlocked(dir Direction, src, dst *net.UDPAddr, now time.Time) bool {
+	fw := h.firewall
+	if fw == nil {
+		return true
+	}
+	f := flow{local: addrKey(src), remote: addrKey(dst)}
+	if dir == Inbound {
+		f = flow{local: addrKey(dst), remote: addrKey(src)}
+	}
+	established := false
+	if expires, ok := fw.flows[f]; ok {
+		if now.Before(expires) {
+			established = true
+		} else {
+			delete(fw.flows, f)
+		}
+	}
+
+	action := fw.Policy
+	for _, r := range fw.Rules {
+		if r.matches(dir, src, dst, established) {
+			action = r.Action
+			break
+		}
+	}
+	if action != Accept {
+		return false
+	}
+	fw.flows[f] = now.Add(fw.FlowTimeout)
+	return true
+}
+
+func (r Rule) matches(dir Direction, src, dst *net.UDPAddr, established bool) bool {
+	switch {
+	case r.Direction != 0 && r.Direction != dir:
+		return false
+	case r.Established && !established:
+		return false
+	case r.Src != nil && !r.Src.Contains(src.IP):
+		return false
+	case r.Dst != nil && !r.Dst.Contains(dst.IP):
+		return false
+	case !r.SrcPorts.contains(src.Port):
+		return false
+	default:
+		return r.DstPorts.contains(dst.Port)
+	}
+}
@@ -0,0 +1,111 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNet_Firewall(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	client := listen(t, nt, "10.0.0.1:5000")
+	server := listen(t, nt, "10.1.0.1:53")
+	other := listen(t, nt, "10.1.0.2:53")
+
+	// Corporate firewall that allows only outbound UDP and replies.
+	_, corp, _ := net.ParseCIDR("10.0.0.0/8")
+	nt.SetFirewall(client.host.addrs[0].IP, &Firewall{
+		Rules: []Rule{
+			{Direction: Inbound, Established: true},
+			{Direction: Outbound, Src: corp, DstPorts: PortRange{Min: 53}},
+		},
+		Policy:      Deny,
+		FlowTimeout: time.Minute,
+	})
+
+	write := func(from, to *PacketConn) error {
+		_, err := from.WriteTo([]byte("hello"), to.LocalAddr())
+		return err
+	}
+
+	// Unsolicited packet is dropped.
+	if err := write(other, client); err != nil {
+		t.Fatal(err)
+	}
+	if client.rx.len() != 0 {
+		t.Error("unsolicited packet delivered")
+	}
+	if drops := other.Stats().Drops[DropFirewall]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	// Reply is accepted.
+	if err := write(client, server); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(server, client); err != nil {
+		t.Fatal(err)
+	}
+	if client.rx.len() != 1 {
+		t.Error("reply not delivered")
+	}
+
+	// Flow is established only with the server.
+	if err := write(other, client); err != nil {
+		t.Fatal(err)
+	}
+	if client.rx.len() != 1 {
+		t.Error("packet of other flow delivered")
+	}
+
+	// Flow expires after timeout.
+	sim.Travel(time.Minute)
+	if err := write(server, client); err != nil {
+		t.Fatal(err)
+	}
+	if client.rx.len() != 1 {
+		t.Error("packet of expired flow delivered")
+	}
+
+	// Outbound packets to ports other than 53 are rejected.
+	if _, err := client.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(10, 1, 0, 1), Port: 80}); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	nt.SetFirewall(client.host.addrs[0].IP, nil)
+	if err := write(other, client); err != nil {
+		t.Fatal(err)
+	}
+	if client.rx.len() != 2 {
+		t.Error("packet not delivered without firewall")
+	}
+}
+
+func TestRule_Matches(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 1000}
+	dst := &net.UDPAddr{IP: net.IPv4(10, 0, 1, 1).To4(), Port: 2000}
+
+	for _, tt := range []struct {
+		rule  Rule
+		match bool
+	}{
+		{Rule{}, true},
+		{Rule{Direction: Inbound}, true},
+		{Rule{Direction: Outbound}, false},
+		{Rule{Src: subnet}, true},
+		{Rule{Dst: subnet}, false},
+		{Rule{SrcPorts: PortRange{Min: 1000}}, true},
+		{Rule{DstPorts: PortRange{Min: 1000, Max: 1999}}, false},
+		{Rule{DstPorts: PortRange{Min: 1000, Max: 2000}}, true},
+		{Rule{Established: true}, false},
+	} {
+		if got := tt.rule.matches(Inbound, src, dst, false); got != tt.match {
+			t.Errorf("%+v: got %v, expected %v", tt.rule, got, tt.match)
+		}
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/gotd/neo
 
-go 1.12
+go 1.18
 
 require golang.org/x/sync v0.2.0
@@ -0,0 +1,270 @@
+package neo
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+)
+
+// limitedBroadcast is the IPv4 limited broadcast address.
+var limitedBroadcast = net.IPv4bcast.To4()
+
+// broadcastIP returns broadcast address of IPv4 subnet a or nil if there is
+// none.
+func broadcastIP(a net.IPNet) net.IP {
+	ip := a.IP.To4()
+	if ip == nil || len(a.Mask) != net.IPv4len {
+		return nil
+	}
+	if ones, _ := a.Mask.Size(); ones >= 31 {
+		return nil
+	}
+	b := make(net.IP, net.IPv4len)
+	for i := range ip {
+		b[i] = ip[i] | ^a.Mask[i]
+	}
+	return b
+}
+
+// broadcasts reports whether ip is broadcast address of a subnet of h.
+func (h *Host) broadcasts(ip net.IP) bool {
+	for _, a := range h.addrs {
+		if b := broadcastIP(a.IPNet); b != nil && b.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcastUnlocked returns directed broadcast address of packet sent from
+// src to dst, or nil if the packet is not a broadcast. The limited broadcast
+// is directed to the subnet of src.
+func (n *Net) broadcastUnlocked(src, dst *net.UDPAddr) net.IP {
+	if !dst.IP.Equal(limitedBroadcast) {
+		for _, h := range n.hosts {
+			if h.broadcasts(dst.IP) {
+				return dst.IP
+			}
+		}
+		return nil
+	}
+	if h := n.owners[ipKey(src.IP)]; h != nil {
+		for _, a := range h.addrs {
+			if a.IP.Equal(src.IP) {
+				return broadcastIP(a.IPNet)
+			}
+		}
+	}
+	return nil
+}
+
+// targetsUnlocked appends to ts link-level targets of packet sent by c from
+// src to dst: every host that may receive broadcast or multicast packet, or
+// dst itself for unicast one.
+func (n *Net) targetsUnlocked(ts []target, c *PacketConn, src, dst *net.UDPAddr) []target {
+	var hosts []*Host
+	switch {
+	case dst.IP.IsMulticast():
+		hosts = n.membersUnlocked(c, src, dst)
+	case dst.IP.Equal(limitedBroadcast):
+		// Limited broadcast to the subnet of source or, if it has none, to
+		// the sending host only.
+		b := n.broadcastUnlocked(src, dst)
+		for _, h := range n.hosts {
+			if h == c.host || (b != nil && h.broadcasts(b)) {
+				hosts = append(hosts, h)
+			}
+		}
+	case n.owners[scopedKey(dst.IP, dst.Zone)] == nil && n.broadcastUnlocked(src, dst) != nil:
+		for _, h := range n.hosts {
+			if h.broadcasts(dst.IP) {
+				hosts = append(hosts, h)
+			}
+		}
+	default:
+		return append(ts, target{to: dst.IP})
+	}
+
+	for _, h := range hosts {
+		ts = append(ts, target{to: h.sourceAddr(src).IP, host: h})
+	}
+	return ts
+}
+
+// membersUnlocked returns hosts that have members of multicast group dst
+// and receive packet sent by c from src. Routers do not forward multicast,
+// so if Net has routers, only hosts on-link with the sender receive it.
+func (n *Net) membersUnlocked(c *PacketConn, src, dst *net.UDPAddr) []*Host {
+	var (
+		key   = scopedKey(dst.IP, dst.Zone)
+		hosts []*Host
+	)
+	for _, h := range n.hosts {
+		if h == c.host && c.noLoopback {
+			continue
+		}
+		if n.routers > 0 && h != c.host && !c.host.onLink(h.sourceAddr(src).IP) {
+			continue
+		}
+		if h.hasMemberUnlocked(key, dst.Port) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// hasMemberUnlocked reports whether h has socket bound to port that joined
+// group with the given key.
+func (h *Host) hasMemberUnlocked(key netip.Addr, port int) bool {
+	for _, c := range h.boundUnlocked(port) {
+		if _, ok := c.groups[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// groupPeersUnlocked returns sockets of h that receive broadcast or multicast
+// packet sent by the from socket to dst. Like on Linux, such packets are
+// received by sockets bound to the unspecified address or to dst itself, and
+// connected sockets receive them only from the remote address.
+func (h *Host) groupPeersUnlocked(from *PacketConn, src, dst *net.UDPAddr) []*PacketConn {
+	key := scopedKey(dst.IP, dst.Zone)
+	var peers []*PacketConn
+	for _, c := range h.boundUnlocked(dst.Port) {
+		if _, ok := c.connectedFrom(src); !ok {
+			continue
+		}
+		a := c.addr.(*net.UDPAddr)
+		if !a.IP.IsUnspecified() && !a.IP.Equal(dst.IP) {
+			continue
+		}
+		if !c.family.has(ipFamily(dst.IP)) {
+			continue
+		}
+		if dst.IP.IsMulticast() {
+			if _, ok := c.groups[key]; !ok {
+				continue
+			}
+			if h == from.host && from.noLoopback {
+				continue
+			}
+		}
+		peers = append(peers, c)
+	}
+	return peers
+}
+
+// groupKey returns key of multicast group joined on interface ifi.
+func groupKey(ifi *net.Interface, group net.Addr) (netip.Addr, net.IP, bool) {
+	var ip net.IP
+	switch a := group.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return netip.Addr{}, nil, false
+	}
+	if !ip.IsMulticast() {
+		return netip.Addr{}, nil, false
+	}
+	var zone string
+	if ifi != nil {
+		zone = ifi.Name
+	}
+	if isLinkLocal(ip) && zone == "" {
+		return netip.Addr{}, nil, false
+	}
+	return scopedKey(ip, zone), ip, true
+}
+
+// JoinGroup joins the multicast group, like JoinGroup of
+// golang.org/x/net/ipv4.PacketConn. Interface is used only as zone of IPv6
+// link-local groups, like ff02::1, and may be nil otherwise.
+func (c *PacketConn) JoinGroup(ifi *net.Interface, group net.Addr) error {
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	key, ip, ok := groupKey(ifi, group)
+	if !ok {
+		return c.opError("set", nil, os.NewSyscallError("setsockopt", syscall.EINVAL))
+	}
+	if !c.family.has(ipFamily(ip)) {
+		return c.opError("set", nil, os.NewSyscallError("setsockopt", syscall.EAFNOSUPPORT))
+	}
+
+	n := c.net
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if _, ok := c.groups[key]; ok {
+		return c.opError("set", nil, os.NewSyscallError("setsockopt", errAddrInUse))
+	}
+	if c.groups == nil {
+		c.groups = map[netip.Addr]struct{}{}
+	}
+	c.groups[key] = struct{}{}
+	return nil
+}
+
+// LeaveGroup leaves the multicast group joined by JoinGroup.
+func (c *PacketConn) LeaveGroup(ifi *net.Interface, group net.Addr) error {
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	key, _, ok := groupKey(ifi, group)
+	if !ok {
+		return c.opError("set", nil, os.NewSyscallError("setsockopt", syscall.EINVAL))
+	}
+
+	n := c.net
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if _, ok := c.groups[key]; !ok {
+		return c.opError("set", nil, os.NewSyscallError("setsockopt", errAddrNotAvail))
+	}
+	delete(c.groups, key)
+	return nil
+}
+
+// MulticastLoopback reports whether multicast packets sent by the socket are
+// delivered to sockets of the same host.
+func (c *PacketConn) MulticastLoopback() (bool, error) {
+	if !c.ok() {
+		return false, syscall.EINVAL
+	}
+	if c.isClosed() {
+		return false, c.opError("get", nil, net.ErrClosed)
+	}
+	n := c.net
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return !c.noLoopback, nil
+}
+
+// SetMulticastLoopback sets whether multicast packets sent by the socket are
+// delivered to sockets of the same host. It is enabled by default.
+func (c *PacketConn) SetMulticastLoopback(on bool) error {
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	n := c.net
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	c.noLoopback = !on
+	return nil
+}
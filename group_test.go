@@ -0,0 +1,153 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// groupNet returns network with hosts 10.0.0.1/24, 10.0.0.2/24 and
+// 10.0.1.1/24, each with socket bound to 0.0.0.0:9.
+func groupNet(t *testing.T) (*Net, []*PacketConn) {
+	t.Helper()
+	nt := NewNet(nil)
+	var conns []*PacketConn
+	for _, addr := range []string{"10.0.0.1/24", "10.0.0.2/24", "10.0.1.1/24"} {
+		h, err := nt.AddHost(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := h.ListenPacket("udp4", ":9")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c.(*PacketConn))
+	}
+	return nt, conns
+}
+
+// receivers returns indexes of conns that received a packet.
+func receivers(conns []*PacketConn) []int {
+	var out []int
+	for i, c := range conns {
+		if _, ok := c.rx.pop(); ok {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNet_Broadcast(t *testing.T) {
+	_, conns := groupNet(t)
+	for _, dst := range []string{"10.0.0.255:9", "255.255.255.255:9"} {
+		a, err := net.ResolveUDPAddr("udp", dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conns[0].WriteTo([]byte("hello"), a); err != nil {
+			t.Fatal(err)
+		}
+		if got := receivers(conns); !equalInts(got, []int{0, 1}) {
+			t.Errorf("%s: received by %v", dst, got)
+		}
+	}
+
+	// Every receiver owns the source address.
+	if _, err := conns[0].WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 255), Port: 9}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	_, from, err := conns[0].ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from.(*net.UDPAddr).IP[0] = 0
+	if _, from, err = conns[1].ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "10.0.0.1:9" {
+		t.Errorf("got source %s", from)
+	}
+}
+
+func TestNet_Multicast(t *testing.T) {
+	_, conns := groupNet(t)
+	group := &net.UDPAddr{IP: net.IPv4(239, 0, 0, 1), Port: 9}
+
+	for _, c := range conns {
+		if err := c.JoinGroup(nil, group); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conns[0].JoinGroup(nil, group); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := conns[0].JoinGroup(nil, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)}); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	send := func() []int {
+		t.Helper()
+		if _, err := conns[0].WriteTo([]byte("hello"), group); err != nil {
+			t.Fatal(err)
+		}
+		return receivers(conns)
+	}
+	if got := send(); !equalInts(got, []int{0, 1, 2}) {
+		t.Errorf("received by %v", got)
+	}
+
+	if err := conns[2].LeaveGroup(nil, group); err != nil {
+		t.Fatal(err)
+	}
+	if err := conns[2].LeaveGroup(nil, group); !errors.Is(err, errAddrNotAvail) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := send(); !equalInts(got, []int{0, 1}) {
+		t.Errorf("received by %v", got)
+	}
+
+	if err := conns[0].SetMulticastLoopback(false); err != nil {
+		t.Fatal(err)
+	}
+	if loop, err := conns[0].MulticastLoopback(); err != nil || loop {
+		t.Errorf("unexpected loopback: %v, %v", loop, err)
+	}
+	if got := send(); !equalInts(got, []int{1}) {
+		t.Errorf("received by %v", got)
+	}
+}
+
+func TestNet_MulticastRouted(t *testing.T) {
+	nt, conns := groupNet(t)
+	if _, err := nt.AddRouter("10.0.0.254/24", "10.0.1.254/24"); err != nil {
+		t.Fatal(err)
+	}
+	group := &net.UDPAddr{IP: net.IPv4(239, 0, 0, 1), Port: 9}
+	for _, c := range conns {
+		if err := c.JoinGroup(nil, group); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Router does not forward multicast to 10.0.1.0/24.
+	if _, err := conns[0].WriteTo([]byte("hello"), group); err != nil {
+		t.Fatal(err)
+	}
+	if got := receivers(conns); !equalInts(got, []int{0, 1}) {
+		t.Errorf("received by %v", got)
+	}
+}
@@ -0,0 +1,319 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Host is a node of Net that owns one or more addresses.
+//
+// Sockets bound to the unspecified address, like 0.0.0.0:53, receive packets
+// sent to any address of the host.
+type Host struct {
+	net   *Net
+	addrs []hostAddr
+
+	// sockets are bound sockets by port, guarded by mux of Net.
+	sockets map[int][]*PacketConn
+	// firewall of the host, guarded by mux of Net.
+	firewall *firewall
+	// routes is the routing table, guarded by mux of Net.
+	routes []Route
+	// forwarding is set for routers.
+	forwarding bool
+}
+
+// hostAddr is an address of a host.
+type hostAddr struct {
+	net.IPNet
+	// zone of IPv6 link-local address.
+	zone string
+}
+
+func (a hostAddr) key() netip.Addr { return scopedKey(a.IP, a.zone) }
+
+// AddHost adds host that owns the given addresses. Address may have a prefix
+// length, like 10.0.0.1/24, that defines subnet of the address. IPv6
+// link-local addresses must have a zone, like fe80::1%eth0/64, and hosts
+// with the same zone share a link.
+func (n *Net) AddHost(addrs ...string) (*Host, error) {
+	return n.addHost(false, addrs)
+}
+
+func (n *Net) addHost(forwarding bool, addrs []string) (*Host, error) {
+	h, err := n.newHost(forwarding, addrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.addHosts(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// newHost returns host of Net with the given addresses without adding it.
+func (n *Net) newHost(forwarding bool, addrs []string) (*Host, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses")
+	}
+	h := &Host{net: n, sockets: map[int][]*PacketConn{}, forwarding: forwarding}
+	for _, s := range addrs {
+		a, err := parseHostAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		h.addrs = append(h.addrs, a)
+	}
+	return h, nil
+}
+
+// addHosts adds hosts hs, either all of them or, if any address is in use,
+// none.
+func (n *Net) addHosts(hs ...*Host) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	added := map[netip.Addr]bool{}
+	for _, h := range hs {
+		for _, a := range h.addrs {
+			if n.owners[a.key()] != nil || added[a.key()] {
+				return errAddrInUse
+			}
+		}
+		for _, a := range h.addrs {
+			added[a.key()] = true
+		}
+	}
+	for _, h := range hs {
+		n.addHostUnlocked(h)
+	}
+	return nil
+}
+
+func (n *Net) addHostUnlocked(h *Host) {
+	if h.forwarding {
+		n.routers++
+	}
+	n.hosts = append(n.hosts, h)
+	for _, a := range h.addrs {
+		n.owners[a.key()] = h
+	}
+}
+
+// hostUnlocked returns host owning ip in zone, adding a single-address host
+// if there is none.
+func (n *Net) hostUnlocked(ip net.IP, zone string) *Host {
+	if h := n.owners[scopedKey(ip, zone)]; h != nil {
+		return h
+	}
+	h := &Host{
+		net:     n,
+		addrs:   []hostAddr{{IPNet: hostNet(ip), zone: zone}},
+		sockets: map[int][]*PacketConn{},
+	}
+	n.addHostUnlocked(h)
+	return h
+}
+
+// parseHostAddr parses IP address with optional zone and prefix length.
+func parseHostAddr(s string) (hostAddr, error) {
+	var prefix string
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s, prefix = s[:i], s[i:]
+	}
+	s, zone := splitZone(s)
+
+	var a hostAddr
+	if prefix != "" {
+		ip, ipNet, err := net.ParseCIDR(s + prefix)
+		if err != nil {
+			return hostAddr{}, err
+		}
+		a.IPNet = net.IPNet{IP: normalizeIP(ip), Mask: ipNet.Mask}
+	} else {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return hostAddr{}, errors.New("bad IP")
+		}
+		a.IPNet = hostNet(ip)
+	}
+	switch {
+	case isLinkLocal(a.IP) && zone == "":
+		return hostAddr{}, errors.New("link-local address requires a zone")
+	case zone != "" && ipFamily(a.IP) != familyIPv6:
+		return hostAddr{}, errors.New("bad zone")
+	}
+	a.zone = zone
+	return a, nil
+}
+
+// hostNet returns single-address network of ip.
+func hostNet(ip net.IP) net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// defaultHostAddrs are addresses of the implicit default host.
+var defaultHostAddrs = []string{"127.0.0.1/8", "::1/128"}
+
+// SetDefaultHost sets host of sockets that Net binds to the unspecified or
+// multicast address. By default, it is a host with loopback addresses
+// 127.0.0.1 and ::1 that is added on first use, or the host that owns
+// 127.0.0.1, if any.
+func (n *Net) SetDefaultHost(h *Host) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.defaultHost = h
+}
+
+// defaultHostUnlocked returns the default host, adding one if there is none.
+func (n *Net) defaultHostUnlocked() *Host {
+	if n.defaultHost != nil {
+		return n.defaultHost
+	}
+	h := &Host{net: n, sockets: map[int][]*PacketConn{}}
+	for _, s := range defaultHostAddrs {
+		a, _ := parseHostAddr(s)
+		if owner := n.owners[a.key()]; owner != nil {
+			if len(h.addrs) == 0 {
+				// Host that owns 127.0.0.1 is the local one.
+				n.defaultHost = owner
+				return owner
+			}
+			continue
+		}
+		h.addrs = append(h.addrs, a)
+	}
+	n.addHostUnlocked(h)
+	n.defaultHost = h
+	return h
+}
+
+// Addrs returns addresses of the host.
+func (h *Host) Addrs() []net.IPNet {
+	addrs := make([]net.IPNet, 0, len(h.addrs))
+	for _, a := range h.addrs {
+		addrs = append(addrs, a.IPNet)
+	}
+	return addrs
+}
+
+// ListenPacket announces on the local network address of the host. Unlike
+// Net.ListenPacket, the address may be unspecified.
+func (h *Host) ListenPacket(network, address string) (net.PacketConn, error) {
+	return h.ListenPacketConfig(ListenConfig{}, network, address)
+}
+
+// ListenPacketConfig is like ListenPacket but with options.
+func (h *Host) ListenPacketConfig(lc ListenConfig, network, address string) (net.PacketConn, error) {
+	n := h.net
+	a, err := n.resolveListen(network, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	pc, err := h.listenUnlocked(lc, network, a)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: a, Err: err}
+	}
+	return pc, nil
+}
+
+func (h *Host) listenUnlocked(lc ListenConfig, network string, a *net.UDPAddr) (*PacketConn, error) {
+	if !h.bindableUnlocked(a.IP, a.Zone) {
+		return nil, os.NewSyscallError("bind", errAddrNotAvail)
+	}
+	n := h.net
+	pc := &PacketConn{
+		net:       n,
+		host:      h,
+		network:   network,
+		addr:      a,
+		family:    socketFamily(network, a.IP),
+		rx:        newBuffer(n.readBuffer),
+		tx:        n.writeBuffer,
+		done:      make(chan struct{}),
+		reusePort: lc.ReusePort,
+	}
+	if err := n.bindUnlocked(pc); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	return pc, nil
+}
+
+// bindableUnlocked reports whether socket of h can bind to ip: unspecified,
+// multicast, broadcast address of the host subnet or the host address.
+func (h *Host) bindableUnlocked(ip net.IP, zone string) bool {
+	return ip.IsUnspecified() || ip.IsMulticast() || h.broadcasts(ip) || h.ownsUnlocked(ip, zone)
+}
+
+func (h *Host) ownsUnlocked(ip net.IP, zone string) bool {
+	k := scopedKey(ip, zone)
+	for _, a := range h.addrs {
+		if a.key() == k {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAddr selects address of the host to send packets to dst from. It
+// considers addresses of the same family and, for link-local dst, of the
+// same zone. Address whose subnet contains dst is preferred, then the one
+// with the longest common prefix with dst.
+func (h *Host) sourceAddr(dst *net.UDPAddr) hostAddr {
+	var (
+		best       = h.addrs[0]
+		bestPrefix = -1
+	)
+	for _, a := range h.addrs {
+		if ipFamily(a.IP) != ipFamily(dst.IP) {
+			continue
+		}
+		if isLinkLocal(dst.IP) && a.zone != dst.Zone {
+			continue
+		}
+		prefix := commonPrefix(a.IP, dst.IP)
+		if a.Contains(dst.IP) {
+			// Beats any address outside of dst subnet.
+			prefix += 129
+		}
+		if prefix > bestPrefix {
+			best, bestPrefix = a, prefix
+		}
+	}
+	return best
+}
+
+// commonPrefix returns length of the common prefix of a and b in bits.
+func commonPrefix(a, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		a, b = a4, b4
+	} else {
+		a, b = a.To16(), b.To16()
+	}
+	var n int
+	for i := range a {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
@@ -0,0 +1,143 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestHost_Wildcard(t *testing.T) {
+	nt := NewNet(nil)
+	h, err := nt.AddHost("10.0.0.1/24", "192.168.1.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := h.ListenPacket("udp", "0.0.0.0:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := c.(*PacketConn)
+	if server.LocalAddr().String() != "0.0.0.0:53" {
+		t.Errorf("unexpected address: %s", server.LocalAddr())
+	}
+
+	for _, tt := range []struct {
+		client string
+		dst    string
+		src    string
+	}{
+		{"10.0.0.2:123", "10.0.0.1:53", "10.0.0.1:53"},
+		{"192.168.1.2:123", "192.168.1.1:53", "192.168.1.1:53"},
+		// Source address is selected by destination, not by the address
+		// that packet was received on.
+		{"192.168.1.3:123", "10.0.0.1:53", "192.168.1.1:53"},
+	} {
+		client := listen(t, nt, tt.client)
+		dst, err := net.ResolveUDPAddr("udp", tt.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.WriteTo([]byte("ping"), dst); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		_, addr, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.WriteTo([]byte("pong"), addr); err != nil {
+			t.Fatal(err)
+		}
+		if _, addr, err = client.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != tt.src {
+			t.Errorf("%s: got reply from %s, expected %s", tt.client, addr, tt.src)
+		}
+	}
+}
+
+func TestHost_Bind(t *testing.T) {
+	nt := NewNet(nil)
+	h, err := nt.AddHost("10.0.0.1", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nt.AddHost("10.0.0.2"); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = h.ListenPacket("udp", "10.0.0.3:53"); !errors.Is(err, errAddrNotAvail) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Wildcard bind without host is on the default host.
+	if c := listen(t, nt, "0.0.0.0:53"); c.host == h {
+		t.Error("wildcard bind on the host")
+	}
+
+	if _, err = h.ListenPacket("udp", "0.0.0.0:53"); err != nil {
+		t.Fatal(err)
+	}
+	// Specific address of the host conflicts with the wildcard, while
+	// Net.ListenPacket binds on the host owning the address.
+	if _, err = nt.ListenPacket("udp", "10.0.0.2:53"); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = h.ListenPacket("udp", "10.0.0.2:54"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		n    int
+	}{
+		{"10.0.0.1", "10.0.0.1", 32},
+		{"10.0.0.1", "10.0.0.2", 30},
+		{"10.0.0.1", "138.0.0.1", 0},
+		{"fe80::1", "fe80::2", 126},
+	} {
+		if n := commonPrefix(net.ParseIP(tt.a), net.ParseIP(tt.b)); n != tt.n {
+			t.Errorf("%s, %s: got %d, expected %d", tt.a, tt.b, n, tt.n)
+		}
+	}
+}
+
+func TestNet_DefaultHost(t *testing.T) {
+	nt := NewNet(nil)
+	for _, address := range []string{":0", "0.0.0.0:53"} {
+		if _, err := nt.ListenPacket("udp", address); err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+	}
+	server := listen(t, nt, "0.0.0.0:54")
+	if addrs := server.host.Addrs(); len(addrs) != 2 || addrs[0].String() != "127.0.0.1/8" {
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+
+	client := listen(t, nt, "10.0.0.1:1")
+	if _, err := client.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 54}); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.rx.len(); n != 1 {
+		t.Errorf("got %d packets, expected 1", n)
+	}
+
+	// Host that owns 127.0.0.1 is the default one.
+	nt = NewNet(nil)
+	h, err := nt.AddHost("127.0.0.1/8", "10.0.0.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := listen(t, nt, "0.0.0.0:53"); c.host != h {
+		t.Error("socket bound on another host")
+	}
+	other, err := nt.AddHost("10.0.0.2/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt.SetDefaultHost(other)
+	if c := listen(t, nt, "0.0.0.0:53"); c.host != other {
+		t.Error("socket bound on another host")
+	}
+}
@@ -0,0 +1,95 @@
+package neo
+
+import (
+	"net"
+	"time"
+)
+
+// Forward passes datagram to the next interceptor or, after the last one, to
+// the link. The delay is added to the delay of the link.
+type Forward func(d Datagram, delay time.Duration)
+
+// Interceptor is a hook that sees every datagram sent over the network before
+// the link model is applied.
+//
+// Interceptor passes the datagram by calling forward, possibly with modified
+// Src, Dst or Payload and with additional delay. Not calling forward drops the
+// datagram and calling it multiple times duplicates it. The forward function
+// must be called before Interceptor returns.
+//
+// Interceptor owns the Payload and may modify it in place. Broadcast and
+// multicast datagrams are intercepted separately for each receiving host;
+// changing their destination only changes the port and group they are
+// delivered to.
+//
+// Interceptors are called from the goroutine of WriteTo and must not block.
+type Interceptor func(d Datagram, forward Forward)
+
+// Intercept adds interceptor of all datagrams. Interceptors are called in the
+// order of addition, global interceptors before ones set by InterceptLink.
+func (n *Net) Intercept(i Interceptor) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.interceptors = append(n.interceptors, i)
+}
+
+// InterceptLink adds interceptor of datagrams on the one-way path from one IP
+// to another.
+func (n *Net) InterceptLink(from, to net.IP, i Interceptor) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	k := linkKey{from: ipKey(from), to: ipKey(to)}
+	n.linkInterceptors[k] = append(n.linkInterceptors[k], i)
+}
+
+// interceptorsUnlocked returns interceptor chain of the path from one IP to
+// another.
+func (n *Net) interceptorsUnlocked(from, to net.IP) []Interceptor {
+	link := n.linkInterceptors[linkKey{from: ipKey(from), to: ipKey(to)}]
+	if len(link) == 0 {
+		return n.interceptors
+	}
+	chain := make([]Interceptor, 0, len(n.interceptors)+len(link))
+	chain = append(chain, n.interceptors...)
+	return append(chain, link...)
+}
+
+// forwarded is a datagram that passed interceptors.
+type forwarded struct {
+	Datagram
+	target target
+	delay  time.Duration
+	// msg is the index of sent message in batch.
+	msg int
+	// pool is the pooled buffer of Payload, if any.
+	pool *payload
+}
+
+// intercept passes d sent to target t through the chain and returns forwarded
+// datagrams.
+func intercept(chain []Interceptor, t target, d Datagram) []forwarded {
+	var out []forwarded
+	var run func(i int, d Datagram, delay time.Duration)
+	run = func(i int, d Datagram, delay time.Duration) {
+		if i < len(chain) {
+			chain[i](d, func(d Datagram, extra time.Duration) {
+				run(i+1, d, delay+extra)
+			})
+			return
+		}
+		f := forwarded{Datagram: d, target: t, delay: delay}
+		if t.host == nil {
+			f.target.to = d.Dst.IP
+		}
+		// Duplicates must not share the payload, because the link model
+		// corrupts it in place.
+		f.Payload, f.pool = newPayload(d.Payload)
+		out = append(out, f)
+	}
+	run(0, d, 0)
+	return out
+}
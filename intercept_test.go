@@ -0,0 +1,132 @@
+package neo
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNet_Intercept(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	var seen int
+	nt.Intercept(func(d Datagram, forward Forward) {
+		seen++
+		switch string(d.Payload) {
+		case "drop":
+		case "twice":
+			forward(d, 0)
+			forward(d, 0)
+		case "late":
+			forward(d, 10*time.Millisecond)
+		default:
+			forward(d, 0)
+		}
+	})
+	nt.InterceptLink(net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1), func(d Datagram, forward Forward) {
+		if string(d.Payload) == "rewrite" {
+			d.Payload = []byte("rewritten")
+		}
+		forward(d, 0)
+	})
+
+	for _, msg := range []string{"drop", "twice", "late", "rewrite"} {
+		if _, err := right.WriteTo([]byte(msg), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seen != 4 {
+		t.Errorf("intercepted %d datagrams, expected 4", seen)
+	}
+	if drops := right.Stats().Drops[DropIntercept]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	read := func() []string {
+		var got []string
+		for left.rx.len() > 0 {
+			p, _ := left.rx.pop()
+			got = append(got, string(p.buf))
+		}
+		return got
+	}
+	if got := read(); len(got) != 3 || got[0] != "twice" || got[1] != "twice" || got[2] != "rewritten" {
+		t.Errorf("got %q", got)
+	}
+	sim.Travel(10 * time.Millisecond)
+	if got := read(); len(got) != 1 || got[0] != "late" {
+		t.Errorf("got %q", got)
+	}
+
+	// Link interceptor is one-way.
+	if _, err := left.WriteTo([]byte("rewrite"), right.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := right.rx.pop(); string(p.buf) != "rewrite" {
+		t.Errorf("got %q", p.buf)
+	}
+}
+
+func TestNet_InterceptRedirect(t *testing.T) {
+	nt := NewNet(nil)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+	spy := listen(t, nt, "10.0.0.3:123")
+
+	nt.Intercept(func(d Datagram, forward Forward) {
+		d.Dst = spy.LocalAddr().(*net.UDPAddr)
+		forward(d, 0)
+	})
+	if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if left.rx.len() != 0 || spy.rx.len() != 1 {
+		t.Errorf("left got %d, spy got %d packets", left.rx.len(), spy.rx.len())
+	}
+}
+
+func TestNet_InterceptSource(t *testing.T) {
+	nt := NewNet(nil)
+	a := listen(t, nt, "10.0.0.1:123")
+	b := listen(t, nt, "10.0.0.2:123")
+
+	// Modified source is seen by the receiver only.
+	nt.Intercept(func(d Datagram, forward Forward) {
+		d.Src.Port = 7
+		d.Src.IP[3] = 99
+		forward(d, 0)
+	})
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	_, from, err := b.ReadFrom(make([]byte, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "10.0.0.99:7" {
+		t.Errorf("bad source: %s", from)
+	}
+	from.(*net.UDPAddr).Port = 4242
+	if a.LocalAddr().String() != "10.0.0.1:123" {
+		t.Errorf("sender address changed to %s", a.LocalAddr())
+	}
+
+	// Without interceptors, receiver gets its own copy too.
+	nt = NewNet(nil)
+	a = listen(t, nt, "10.0.0.1:123")
+	b = listen(t, nt, "10.0.0.2:123")
+	if _, err = a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, from, err = b.ReadFrom(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	from.(*net.UDPAddr).IP[3] = 99
+	if a.LocalAddr().String() != "10.0.0.1:123" {
+		t.Errorf("sender address changed to %s", a.LocalAddr())
+	}
+}
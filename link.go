@@ -0,0 +1,232 @@
+package neo
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// Latency is a distribution of one-way packet delay.
+type Latency interface {
+	// Delay returns next delay sampled using r.
+	Delay(r *rand.Rand) time.Duration
+}
+
+// FixedLatency is a constant delay.
+type FixedLatency time.Duration
+
+// Delay implements Latency.
+func (l FixedLatency) Delay(*rand.Rand) time.Duration { return time.Duration(l) }
+
+// UniformLatency is a delay uniformly distributed in [Min, Max].
+type UniformLatency struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Delay implements Latency.
+func (l UniformLatency) Delay(r *rand.Rand) time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(r.Int63n(int64(l.Max-l.Min)+1))
+}
+
+// NormalLatency is a normally distributed delay. Negative samples are
+// clamped to zero.
+type NormalLatency struct {
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+// Delay implements Latency.
+func (l NormalLatency) Delay(r *rand.Rand) time.Duration {
+	d := l.Mean + time.Duration(r.NormFloat64()*float64(l.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Link describes properties of a one-way path between two peers.
+//
+// The zero value is a perfect link that delivers packets instantly.
+type Link struct {
+	// Latency of the link, nil means no delay.
+	Latency Latency
+
+	// Loss is the probability of dropping a packet.
+	Loss float64
+	// Duplicate is the probability of delivering a packet twice.
+	Duplicate float64
+	// Reorder is the probability of delaying a packet for a random duration
+	// up to ReorderWindow, so packets sent after it may arrive earlier.
+	Reorder       float64
+	ReorderWindow time.Duration
+	// Corrupt is the probability of flipping a random bit of a packet.
+	Corrupt float64
+
+	// Bandwidth is the link capacity in payload bytes per second, zero means
+	// unlimited. Packets are serialized one after another, so each packet is
+	// delayed by the time it spends waiting in the bottleneck queue plus its
+	// own serialization time.
+	Bandwidth int
+	// Queue is the bottleneck queue discipline, nil means unlimited queue.
+	Queue Queue
+
+	// MTU is the maximum size of IP packet on the link, including IP and UDP
+	// headers, zero means unlimited. Writes of larger packets fail with
+	// EMSGSIZE unless Fragment is set.
+	MTU int
+	// Fragment enables IP fragmentation of packets larger than MTU.
+	Fragment bool
+}
+
+// transmission is a packet scheduled for delivery after delay.
+type transmission struct {
+	delay  time.Duration
+	packet packet
+}
+
+// target is a destination of a packet on the link level.
+type target struct {
+	// to is the address of the link end.
+	to net.IP
+	// host receives broadcast or multicast packet, nil for unicast.
+	host *Host
+}
+
+// delivery is a transmission of packet sent to dst to target, arriving at
+// the given time.
+type delivery struct {
+	// msg is the index of sent message in batch.
+	msg int
+	dst *net.UDPAddr
+	at  time.Time
+	target
+	transmission
+}
+
+// transmit applies link model to p sent at now that enters the link after
+// the given delay and appends resulting transmissions to ts, with delays
+// counted from now. If the packet is dropped, there are no transmissions and
+// the drop reason is returned. State s may be nil if link has unlimited
+// bandwidth.
+func (l Link) transmit(ts []transmission, r *rand.Rand, s *linkState, now time.Time, after time.Duration, p packet) ([]transmission, DropReason) {
+	queued := after
+	if l.Bandwidth > 0 {
+		wait, ok := s.enqueue(l, r, now, now.Add(after), len(p.buf))
+		if !ok {
+			return ts, DropQueue
+		}
+		queued += wait
+	}
+	if chance(r, l.Loss) {
+		return ts, DropLoss
+	}
+	if chance(r, l.Corrupt) && len(p.buf) > 0 {
+		bit := r.Intn(len(p.buf) * 8)
+		p.buf[bit/8] ^= 1 << uint(bit%8)
+	}
+	ts = append(ts, transmission{delay: queued + l.delay(r), packet: p})
+	if chance(r, l.Duplicate) {
+		// Every transmission owns its buffer.
+		ts = append(ts, transmission{delay: queued + l.delay(r), packet: p.clone()})
+	}
+	return ts, 0
+}
+
+// delay samples delay of a single packet.
+func (l Link) delay(r *rand.Rand) time.Duration {
+	var d time.Duration
+	if l.Latency != nil {
+		d = l.Latency.Delay(r)
+	}
+	if l.ReorderWindow > 0 && chance(r, l.Reorder) {
+		d += time.Duration(r.Int63n(int64(l.ReorderWindow)) + 1)
+	}
+	return d
+}
+
+// chance reports whether event with probability p happened.
+func chance(r *rand.Rand, p float64) bool {
+	return p > 0 && r.Float64() < p
+}
+
+type linkKey struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+// SetLink sets properties of the one-way path from one IP to another. Call
+// it twice with swapped arguments to configure both directions.
+//
+// Link set by SetLink takes precedence over SetPeerLink and SetDefaultLink.
+func (n *Net) SetLink(from, to net.IP, l Link) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.links[linkKey{from: ipKey(from), to: ipKey(to)}] = l
+}
+
+// SetPeerLink sets properties of paths to and from the given IP. If both
+// peers have links, the link of the destination is used.
+func (n *Net) SetPeerLink(ip net.IP, l Link) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.peerLinks[ipKey(ip)] = l
+}
+
+// SetDefaultLink sets properties of paths that have no link set by SetLink or
+// SetPeerLink.
+func (n *Net) SetDefaultLink(l Link) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.defaultLink = l
+}
+
+// Seed seeds the random source used by link models, making simulation
+// reproducible.
+func (n *Net) Seed(seed int64) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.rand = rand.New(rand.NewSource(seed))
+}
+
+// linkUnlocked returns link for the path between from and to and state of its
+// bottleneck queue. Paths sharing a peer link also share the queue.
+func (n *Net) linkUnlocked(from, to net.IP) (Link, *linkState) {
+	k := linkKey{from: ipKey(from), to: ipKey(to)}
+	if l, ok := n.links[k]; ok {
+		return l, n.linkStateUnlocked(l, k)
+	}
+	if l, ok := n.peerLinks[k.to]; ok {
+		return l, n.linkStateUnlocked(l, linkKey{to: k.to})
+	}
+	if l, ok := n.peerLinks[k.from]; ok {
+		return l, n.linkStateUnlocked(l, linkKey{from: k.from})
+	}
+	return n.defaultLink, n.linkStateUnlocked(n.defaultLink, k)
+}
+
+// linkStateUnlocked returns state for key k, or nil if link l has unlimited
+// bandwidth and needs no state.
+func (n *Net) linkStateUnlocked(l Link, k linkKey) *linkState {
+	if l.Bandwidth <= 0 {
+		return nil
+	}
+	s, ok := n.linkStates[k]
+	if !ok {
+		s = &linkState{}
+		n.linkStates[k] = s
+	}
+	return s
+}
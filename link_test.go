@@ -0,0 +1,171 @@
+package neo
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNet_Latency(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	nt.SetDefaultLink(Link{Latency: FixedLatency(100 * time.Millisecond)})
+
+	left, err := nt.ListenPacket("udp", "10.0.0.1:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := nt.ListenPacket("udp", "10.0.0.2:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Packet is still in flight, so read must hit the deadline.
+	sim.Travel(99 * time.Millisecond)
+	if err = left.SetReadDeadline(sim.Now()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if _, _, err = left.ReadFrom(buf); !errors.Is(err, ErrDeadline) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sim.Travel(time.Millisecond)
+	if err = left.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	n, addr, err := left.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "10.0.0.2:123" {
+		t.Errorf("bad addr: %s", addr)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("bad message: %s", buf[:n])
+	}
+}
+
+func TestNet_LinkPrecedence(t *testing.T) {
+	nt := NewNet(nil)
+	nt.SetDefaultLink(Link{Latency: FixedLatency(1)})
+	nt.SetPeerLink(net.ParseIP("10.0.0.2"), Link{Latency: FixedLatency(2)})
+	nt.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), Link{Latency: FixedLatency(3)})
+
+	for _, tt := range []struct {
+		from, to string
+		delay    time.Duration
+	}{
+		{"10.0.0.1", "10.0.0.2", 3},
+		{"10.0.0.2", "10.0.0.1", 2},
+		{"10.0.0.3", "10.0.0.2", 2},
+		{"10.0.0.1", "10.0.0.3", 1},
+	} {
+		l, _ := nt.linkUnlocked(net.ParseIP(tt.from), net.ParseIP(tt.to))
+		if d := l.Latency.Delay(nil); d != tt.delay {
+			t.Errorf("%s -> %s: got %s, expected %s", tt.from, tt.to, d, tt.delay)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	for _, l := range []Latency{
+		UniformLatency{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond},
+		NormalLatency{Mean: 15 * time.Millisecond, StdDev: 5 * time.Millisecond},
+	} {
+		a := rand.New(rand.NewSource(1))
+		b := rand.New(rand.NewSource(1))
+		for i := 0; i < 100; i++ {
+			d := l.Delay(a)
+			if d != l.Delay(b) {
+				t.Fatalf("%T: not reproducible", l)
+			}
+			if d < 0 {
+				t.Fatalf("%T: negative delay %s", l, d)
+			}
+			if u, ok := l.(UniformLatency); ok && (d < u.Min || d > u.Max) {
+				t.Fatalf("%T: %s out of range", l, d)
+			}
+		}
+	}
+}
+
+func TestLink_Transmit(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	p := packet{buf: []byte("hello")}
+
+	if sent, reason := (Link{Loss: 1}).transmit(nil, r, nil, time.Time{}, 0, p); len(sent) != 0 || reason != DropLoss {
+		t.Errorf("lost packet delivered %d times", len(sent))
+	}
+	if sent, _ := (Link{Duplicate: 1}).transmit(nil, r, nil, time.Time{}, 0, p); len(sent) != 2 {
+		t.Errorf("duplicated packet delivered %d times", len(sent))
+	}
+
+	sent, _ := (Link{Corrupt: 1}).transmit(nil, r, nil, time.Time{}, 0, packet{buf: []byte("hello")})
+	var flipped int
+	for i, b := range sent[0].packet.buf {
+		for x := b ^ p.buf[i]; x != 0; x &= x - 1 {
+			flipped++
+		}
+	}
+	if flipped != 1 {
+		t.Errorf("corrupted packet has %d flipped bits", flipped)
+	}
+
+	const window = 10 * time.Millisecond
+	l := Link{
+		Latency:       FixedLatency(window),
+		Reorder:       1,
+		ReorderWindow: window,
+	}
+	for i := 0; i < 100; i++ {
+		sent, _ := l.transmit(nil, r, nil, time.Time{}, 0, p)
+		d := sent[0].delay
+		if d <= window || d > 2*window {
+			t.Fatalf("reordered packet has delay %s", d)
+		}
+	}
+}
+
+func TestNet_Loss(t *testing.T) {
+	const total = 1000
+
+	received := func(seed int64) int {
+		nt := NewNet(nil)
+		nt.Seed(seed)
+		nt.SetDefaultLink(Link{Loss: 0.5})
+
+		left, err := nt.ListenPacket("udp", "10.0.0.1:123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		right, err := nt.ListenPacket("udp", "10.0.0.2:123")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < total; i++ {
+			if _, err = right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if lost := right.(*PacketConn).Stats().Drops[DropLoss]; int(lost)+left.(*PacketConn).rx.len() != total {
+			t.Errorf("%d packets lost, %d received", lost, left.(*PacketConn).rx.len())
+		}
+		return left.(*PacketConn).rx.len()
+	}
+
+	got := received(1)
+	if got < total/4 || got > total*3/4 {
+		t.Errorf("received %d of %d packets", got, total)
+	}
+	if again := received(1); again != got {
+		t.Errorf("not reproducible: %d != %d", again, got)
+	}
+}
@@ -3,6 +3,7 @@ package neo
 import "time"
 
 type moment struct {
+	id   int
 	when time.Time
 	do   func(time time.Time)
 }
@@ -19,7 +20,12 @@ func (m moments) Len() int {
 	return len(m)
 }
 
+// Less orders moments by time. Moments planned for the same time are ordered
+// by ID, i.e. in the order they were planned.
 func (m moments) Less(i, j int) bool {
+	if m[i].when.Equal(m[j].when) {
+		return m[i].id < m[j].id
+	}
 	return m[i].when.Before(m[j].when)
 }
 
@@ -0,0 +1,12 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package neo
+
+import "syscall"
+
+// Flags of truncated packet and truncated control messages.
+const (
+	msgTrunc  = syscall.MSG_TRUNC
+	msgCtrunc = syscall.MSG_CTRUNC
+)
@@ -0,0 +1,11 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package neo
+
+// Flags of truncated packet and truncated control messages. The platform has
+// no such flags, so values of Linux are used.
+const (
+	msgTrunc  = 0x20
+	msgCtrunc = 0x8
+)
@@ -0,0 +1,97 @@
+package neo
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	udpHeaderLen  = 8
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	// ipv6FragmentLen is the length of IPv6 fragment extension header.
+	ipv6FragmentLen = 8
+)
+
+// packetLen returns length of IP packet with UDP payload of the given size
+// sent to ip.
+func packetLen(ip net.IP, size int) int {
+	if ip.To4() != nil {
+		return ipv4HeaderLen + udpHeaderLen + size
+	}
+	return ipv6HeaderLen + udpHeaderLen + size
+}
+
+// maxPayload returns the maximum size of UDP payload sent to ip.
+func maxPayload(ip net.IP) int {
+	if ip.To4() != nil {
+		return 0xffff - ipv4HeaderLen - udpHeaderLen
+	}
+	// Length of IPv6 payload does not include the IPv6 header.
+	return 0xffff - udpHeaderLen
+}
+
+// fits reports whether packet with UDP payload of the given size sent to ip
+// can pass the link without fragmentation.
+func (l Link) fits(ip net.IP, size int) bool {
+	return l.MTU <= 0 || packetLen(ip, size) <= l.MTU
+}
+
+// fragments splits payload of packet sent to ip into parts carried by IP
+// fragments. Parts share memory with the payload.
+func (l Link) fragments(ip net.IP, payload []byte) [][]byte {
+	// Fragment data is a multiple of 8 bytes, and the first fragment also
+	// carries UDP header.
+	size := l.MTU - ipv4HeaderLen
+	if ip.To4() == nil {
+		size = l.MTU - ipv6HeaderLen - ipv6FragmentLen
+	}
+	size &^= 7
+	if size < 8 {
+		size = 8
+	}
+	var parts [][]byte
+	for offset := 0; offset < udpHeaderLen+len(payload); offset += size {
+		start, end := offset-udpHeaderLen, offset+size-udpHeaderLen
+		if start < 0 {
+			start = 0
+		}
+		if end > len(payload) {
+			end = len(payload)
+		}
+		parts = append(parts, payload[start:end])
+	}
+	return parts
+}
+
+// transmitFragmented is like transmit, but fragments packet sent to ip if it
+// does not fit into MTU. Every fragment passes the link model, and packet
+// arrives with the last fragment or is lost with any of them. Duplicate
+// fragments are discarded by reassembly.
+func (l Link) transmitFragmented(ts []transmission, r *rand.Rand, s *linkState, now time.Time, after time.Duration, ip net.IP, p packet) ([]transmission, DropReason) {
+	if l.fits(ip, len(p.buf)) {
+		return l.transmit(ts, r, s, now, after, p)
+	}
+	var (
+		delay time.Duration
+		buf   [2]transmission
+	)
+	for _, part := range l.fragments(ip, p.buf) {
+		// Corruption of the fragment is visible in the packet, because they
+		// share memory.
+		fragment := p
+		fragment.buf = part
+		fs, reason := l.transmit(buf[:0], r, s, now, after, fragment)
+		if reason != 0 {
+			return ts, reason
+		}
+		if fs[0].delay > delay {
+			delay = fs[0].delay
+		}
+		for _, dup := range fs[1:] {
+			dup.packet.release()
+		}
+	}
+	return append(ts, transmission{delay: delay, packet: p}), 0
+}
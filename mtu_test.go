@@ -0,0 +1,123 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNet_MTU(t *testing.T) {
+	nt := NewNet(nil)
+	nt.SetDefaultLink(Link{MTU: 1500})
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	// 1500 - 20 (IPv4) - 8 (UDP).
+	if _, err := right.WriteTo(make([]byte, 1472), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := right.WriteTo(make([]byte, 1473), left.LocalAddr()); !errors.Is(err, errMsgSize) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := right.WriteTo(make([]byte, 65508), left.LocalAddr()); !errors.Is(err, errMsgSize) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := left.rx.len(); n != 1 {
+		t.Errorf("got %d packets, expected 1", n)
+	}
+}
+
+func TestNet_Fragment(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	nt.SetDefaultLink(Link{
+		MTU:       1500,
+		Fragment:  true,
+		Bandwidth: 1000,
+	})
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	msg := make([]byte, 3000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if _, err := right.WriteTo(msg, left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	// Packet arrives with the last fragment, after serialization of all of
+	// them.
+	sim.Travel(2999 * time.Millisecond)
+	if left.rx.len() != 0 {
+		t.Fatal("packet arrived before the last fragment")
+	}
+	sim.Travel(time.Millisecond)
+	p, ok := left.rx.pop()
+	if !ok {
+		t.Fatal("packet not reassembled")
+	}
+	if string(p.buf) != string(msg) {
+		t.Error("bad reassembled packet")
+	}
+
+	// Loss of any fragment loses the packet.
+	lossy := Link{MTU: 1280, Fragment: true, Loss: 1}
+	nt.SetLink(net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1), lossy)
+	if _, err := right.WriteTo(msg, left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if drops := right.Stats().Drops[DropLoss]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+}
+
+func TestLink_Fragments(t *testing.T) {
+	for _, tt := range []struct {
+		ip    net.IP
+		mtu   int
+		size  int
+		parts []int
+	}{
+		{net.IPv4(10, 0, 0, 1), 1500, 1472, []int{1472}},
+		// 1480 bytes of data per fragment, first one carries UDP header.
+		{net.IPv4(10, 0, 0, 1), 1500, 3000, []int{1472, 1480, 48}},
+		// 1232 bytes of data per fragment after IPv6 and fragment headers.
+		{net.ParseIP("fd00::1"), 1280, 2000, []int{1224, 776}},
+	} {
+		var got []int
+		for _, part := range (Link{MTU: tt.mtu}).fragments(tt.ip, make([]byte, tt.size)) {
+			got = append(got, len(part))
+		}
+		if !equalInts(got, tt.parts) {
+			t.Errorf("%s/%d: got %v, expected %v", tt.ip, tt.size, got, tt.parts)
+		}
+	}
+}
+
+func TestPacketConn_ReadMsgUDP(t *testing.T) {
+	nt := NewNet(nil)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	for _, msg := range []string{"hello", "hi"} {
+		if _, err := right.WriteTo([]byte(msg), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 4)
+	n, _, flags, addr, err := left.ReadMsgUDP(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 || flags&msgTrunc == 0 || addr.String() != "10.0.0.2:123" {
+		t.Errorf("got n=%d flags=%#x addr=%s", n, flags, addr)
+	}
+	if n, _, flags, _, err = left.ReadMsgUDP(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || flags&msgTrunc != 0 {
+		t.Errorf("got n=%d flags=%#x", n, flags)
+	}
+}
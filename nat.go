@@ -0,0 +1,255 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// defaultNATTimeout is the default idle timeout of NAT mappings, like
+// nf_conntrack_udp_timeout of Linux.
+const defaultNATTimeout = 30 * time.Second
+
+// errNATInUse is returned when outside address is used by another NAT.
+var errNATInUse = errors.New("outside address is in use")
+
+// NAT is a Network Address Translation between private networks and a public
+// address.
+//
+// Packets sent from Inside networks to other addresses get the Outside
+// address and a mapped port as source. Mapping keeps the private port if it
+// is free and is the same for all destinations. Packets sent to the Outside
+// address are delivered to the private address of the mapping or of the
+// forwarded port, others are dropped.
+//
+// Example of two peers behind NATs:
+//
+//	[ A ] <-----> [ NAT1 ] <-----> [ NAT2 ] <-----> [ B ]
+//	 IPa            IPa'             IPb'            IPb
+//
+//	IPa  = 10.5.0.1:30000
+//	IPa' = 83.30.100.1:30000
+//	IPb' = 91.10.100.1:13000, forwarded to IPb
+//	IPb  = 10.1.0.1:20000
+//
+// A sends packet to IPb', which NAT1 translates to come from IPa' and NAT2
+// forwards to IPb. B observes packet from IPa' and replies to it, NAT1 has
+// the mapping of IPa' and delivers the reply to A.
+type NAT struct {
+	// Inside are private networks behind NAT.
+	Inside []*net.IPNet
+	// Outside is the public address.
+	Outside net.IP
+	// Forward maps public ports to private addresses.
+	Forward map[int]*net.UDPAddr
+	// Restricted enables port-restricted filtering: packets to mapped port
+	// are accepted only from addresses the mapping sent packets to.
+	// Otherwise mapping accepts packets from anyone, like full cone NAT.
+	Restricted bool
+	// Timeout is the idle timeout of mappings on the bound clock, zero
+	// means 30 seconds.
+	Timeout time.Duration
+}
+
+// nat is a NAT with its mappings, guarded by mux of Net.
+type nat struct {
+	NAT
+	// out are mappings by private address.
+	out map[netip.AddrPort]*mapping
+	// in are mappings by public port.
+	in map[int]*mapping
+	// next is the next candidate port of mapping.
+	next int
+}
+
+// mapping is a mapping of private address to public port.
+type mapping struct {
+	private *net.UDPAddr
+	port    int
+	expires time.Time
+	// remotes are destinations of the mapping for restricted NAT.
+	remotes map[netip.AddrPort]struct{}
+}
+
+// AddNAT adds NAT to the network. Outside address must not be in Inside
+// networks or used by another NAT, and forwarded ports must point to Inside
+// networks.
+//
+// Packets are translated once, so nested NATs are not supported. Links,
+// partitions and routes apply to addresses as sent, that is, between the
+// private source and the public destination. If Net has routers, Outside
+// should be an address of a router, so that packets have a path to it.
+func (n *Net) AddNAT(cfg *NAT) error {
+	t, err := newNAT(cfg)
+	if err != nil {
+		return err
+	}
+	return n.addNAT(t)
+}
+
+// newNAT returns NAT of cfg, checking everything but the outside address
+// being in use.
+func newNAT(cfg *NAT) (*nat, error) {
+	if cfg.Outside == nil {
+		return nil, errors.New("no outside address")
+	}
+	if len(cfg.Inside) == 0 {
+		return nil, errors.New("no inside networks")
+	}
+	t := &nat{
+		NAT:  *cfg,
+		out:  map[netip.AddrPort]*mapping{},
+		in:   map[int]*mapping{},
+		next: defaultEphemeralMin,
+	}
+	t.Outside = normalizeIP(cfg.Outside)
+	t.Inside = append([]*net.IPNet(nil), cfg.Inside...)
+	t.Forward = map[int]*net.UDPAddr{}
+	for port, a := range cfg.Forward {
+		if port <= 0 || port > 0xffff {
+			return nil, errors.New("bad forwarded port")
+		}
+		if a == nil || !t.inside(a.IP) {
+			return nil, errors.New("forwarded port points outside")
+		}
+		t.Forward[port] = &net.UDPAddr{IP: normalizeIP(a.IP), Port: a.Port}
+	}
+	if t.inside(t.Outside) {
+		return nil, errors.New("outside address is inside")
+	}
+	if t.Timeout <= 0 {
+		t.Timeout = defaultNATTimeout
+	}
+	return t, nil
+}
+
+// addNAT adds NAT t unless its outside address is in use.
+func (n *Net) addNAT(t *nat) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	if n.natUnlocked(t.Outside) != nil {
+		return errNATInUse
+	}
+	n.nats = append(n.nats, t)
+	return nil
+}
+
+// natInUse reports whether ip is the outside address of a NAT.
+func (n *Net) natInUse(ip net.IP) bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.natUnlocked(ip) != nil
+}
+
+// natUnlocked returns NAT with the given outside address.
+func (n *Net) natUnlocked(ip net.IP) *nat {
+	for _, t := range n.nats {
+		if t.Outside.Equal(ip) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (t *nat) inside(ip net.IP) bool {
+	for _, subnet := range t.Inside {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// natOutUnlocked returns source address of packet sent from src to dst at
+// now after translation by NAT, if any. It returns false if NAT has no free
+// ports.
+func (n *Net) natOutUnlocked(src, dst *net.UDPAddr, now time.Time) (*net.UDPAddr, bool) {
+	for _, t := range n.nats {
+		if !t.inside(src.IP) || t.inside(dst.IP) {
+			continue
+		}
+		m, ok := t.mapUnlocked(src, now)
+		if !ok {
+			return nil, false
+		}
+		m.expires = now.Add(t.Timeout)
+		if t.Restricted {
+			m.remotes[addrKey(dst)] = struct{}{}
+		}
+		// Receivers own the source address, so it does not share Outside.
+		return cloneUDPAddr(&net.UDPAddr{IP: t.Outside, Port: m.port}), true
+	}
+	return src, true
+}
+
+// mapUnlocked returns mapping of private address src, creating one if there
+// is none.
+func (t *nat) mapUnlocked(src *net.UDPAddr, now time.Time) (*mapping, bool) {
+	k := addrKey(src)
+	if m := t.out[k]; m != nil && now.Before(m.expires) {
+		return m, true
+	}
+	// Forwarded port maps its private address back.
+	for port, a := range t.Forward {
+		if addrKey(a) == k {
+			m := &mapping{private: a, port: port, remotes: map[netip.AddrPort]struct{}{}}
+			t.out[k] = m
+			return m, true
+		}
+	}
+	free := func(port int) bool {
+		if _, ok := t.Forward[port]; ok {
+			return false
+		}
+		m := t.in[port]
+		return m == nil || !now.Before(m.expires)
+	}
+	port := src.Port
+	if !free(port) {
+		size := defaultEphemeralMax - defaultEphemeralMin + 1
+		for i := 0; i < size && !free(t.next); i++ {
+			if t.next++; t.next > defaultEphemeralMax {
+				t.next = defaultEphemeralMin
+			}
+		}
+		if !free(t.next) {
+			return nil, false
+		}
+		port = t.next
+	}
+	if old := t.in[port]; old != nil && t.out[addrKey(old.private)] == old {
+		delete(t.out, addrKey(old.private))
+	}
+	m := &mapping{private: src, port: port, remotes: map[netip.AddrPort]struct{}{}}
+	t.out[k] = m
+	t.in[port] = m
+	return m, true
+}
+
+// natInUnlocked returns destination address of packet sent from src to dst
+// at now after translation by NAT, if any. It returns false if NAT drops the
+// packet.
+func (n *Net) natInUnlocked(src, dst *net.UDPAddr, now time.Time) (*net.UDPAddr, bool) {
+	t := n.natUnlocked(dst.IP)
+	if t == nil {
+		return dst, true
+	}
+	if a, ok := t.Forward[dst.Port]; ok {
+		return a, true
+	}
+	m := t.in[dst.Port]
+	if m == nil || !now.Before(m.expires) {
+		return nil, false
+	}
+	if t.Restricted {
+		if _, ok := m.remotes[addrKey(src)]; !ok {
+			return nil, false
+		}
+	}
+	m.expires = now.Add(t.Timeout)
+	return m.private, true
+}
@@ -0,0 +1,148 @@
+package neo
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func addNAT(t *testing.T, nt *Net, inside, outside string, forward map[int]*net.UDPAddr) *NAT {
+	t.Helper()
+	_, subnet, err := net.ParseCIDR(inside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &NAT{Inside: []*net.IPNet{subnet}, Outside: net.ParseIP(outside), Forward: forward}
+	if err := nt.AddNAT(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestNet_NAT(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	a := listen(t, nt, "10.5.0.1:30000")
+	b := listen(t, nt, "10.1.0.1:20000")
+	addNAT(t, nt, "10.5.0.0/16", "83.30.100.1", nil)
+	addNAT(t, nt, "10.1.0.0/16", "91.10.100.1", map[int]*net.UDPAddr{
+		13000: {IP: net.IPv4(10, 1, 0, 1), Port: 20000},
+	})
+
+	buf := make([]byte, 1024)
+	if _, err := a.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(91, 10, 100, 1), Port: 13000}); err != nil {
+		t.Fatal(err)
+	}
+	_, from, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "83.30.100.1:30000" {
+		t.Errorf("bad source: %s", from)
+	}
+
+	if _, err = b.WriteTo([]byte("hello"), from); err != nil {
+		t.Fatal(err)
+	}
+	if _, from, err = a.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "91.10.100.1:13000" {
+		t.Errorf("bad source: %s", from)
+	}
+
+	// Port of another mapping is not preserved.
+	c := listen(t, nt, "10.5.0.2:30000")
+	if _, err = c.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(91, 10, 100, 1), Port: 13000}); err != nil {
+		t.Fatal(err)
+	}
+	if _, from, err = b.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if addr := from.(*net.UDPAddr); !addr.IP.Equal(net.IPv4(83, 30, 100, 1)) || addr.Port == 30000 {
+		t.Errorf("bad source: %s", from)
+	}
+	// Receiver owns the translated source address.
+	from.(*net.UDPAddr).IP[0] = 0
+	if outside := nt.nats[0].Outside; !outside.Equal(net.IPv4(83, 30, 100, 1)) {
+		t.Errorf("outside address changed to %s", outside)
+	}
+
+	// Unsolicited packet to a port that is neither mapped nor forwarded is
+	// dropped.
+	if _, err = b.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(83, 30, 100, 1), Port: 40000}); err != nil {
+		t.Fatal(err)
+	}
+	if drops := b.Stats().Drops[DropNAT]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	// Mapping expires after idle timeout.
+	sim.Travel(defaultNATTimeout)
+	if _, err = b.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(83, 30, 100, 1), Port: 30000}); err != nil {
+		t.Fatal(err)
+	}
+	if drops := b.Stats().Drops[DropNAT]; drops != 2 {
+		t.Errorf("got %d drops, expected 2", drops)
+	}
+	if n := a.rx.len(); n != 0 {
+		t.Errorf("got %d packets, expected none", n)
+	}
+}
+
+func TestNet_NATRestricted(t *testing.T) {
+	nt := NewNet(nil)
+	b := listen(t, nt, "91.10.100.1:13000")
+	other := listen(t, nt, "91.10.100.2:13000")
+	cfg := addNAT(t, nt, "10.5.0.0/16", "83.30.100.1", nil)
+	if err := nt.AddNAT(cfg); err == nil {
+		t.Error("expected error for the same outside address")
+	}
+	_, subnet, _ := net.ParseCIDR("10.6.0.0/16")
+	if err := nt.AddNAT(&NAT{Inside: []*net.IPNet{subnet}, Outside: net.ParseIP("84.30.100.1"), Restricted: true}); err != nil {
+		t.Fatal(err)
+	}
+	a := listen(t, nt, "10.6.0.1:30000")
+
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	_, from, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.WriteTo([]byte("hello"), from); err != nil {
+		t.Fatal(err)
+	}
+	if drops := other.Stats().Drops[DropNAT]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+	if _, err = b.WriteTo([]byte("hello"), from); err != nil {
+		t.Fatal(err)
+	}
+	if n := a.rx.len(); n != 1 {
+		t.Errorf("got %d packets, expected 1", n)
+	}
+}
+
+func TestNet_AddNAT(t *testing.T) {
+	nt := NewNet(nil)
+	_, inside, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, cfg := range []*NAT{
+		{Inside: []*net.IPNet{inside}},
+		{Outside: net.ParseIP("83.30.100.1")},
+		{Inside: []*net.IPNet{inside}, Outside: net.ParseIP("10.0.0.1")},
+		{Inside: []*net.IPNet{inside}, Outside: net.ParseIP("83.30.100.1"), Forward: map[int]*net.UDPAddr{
+			80: {IP: net.IPv4(11, 0, 0, 1), Port: 80},
+		}},
+		{Inside: []*net.IPNet{inside}, Outside: net.ParseIP("83.30.100.1"), Forward: map[int]*net.UDPAddr{
+			0: {IP: net.IPv4(10, 0, 0, 1), Port: 80},
+		}},
+	} {
+		if err := nt.AddNAT(cfg); err == nil {
+			t.Errorf("%+v: expected error", cfg)
+		}
+	}
+}
@@ -1,84 +1,352 @@
 package neo
 
 import (
-	"errors"
+	"math/rand"
 	"net"
+	"net/netip"
+	"os"
 	"strconv"
 	"sync"
 	"syscall"
 	"time"
 )
 
-// Net is virtual "net" package, implements mesh of peers.
+// NewNet returns new virtual network that uses t for delayed delivery and
+// deadlines. If t is nil, the real clock is used.
+func NewNet(t *Time) *Net {
+	n := &Net{time: t}
+	n.initUnlocked()
+	return n
+}
+
+// Net is virtual "net" package, implements mesh of hosts.
+//
+// All methods are goroutine-safe.
 type Net struct {
-	peers map[string]*PacketConn
+	// time is the bound clock, nil means real clock.
+	time *Time
+
+	mux         sync.Mutex
+	rand        *rand.Rand
+	hosts       []*Host
+	owners      map[netip.Addr]*Host
+	links       map[linkKey]Link
+	linkStates  map[linkKey]*linkState
+	peerLinks   map[netip.Addr]Link
+	defaultLink Link
+
+	nats []*nat
+
+	// routers is the number of forwarding hosts. Net without routers is a
+	// flat mesh.
+	routers int
+
+	blocked       map[linkKey]struct{}
+	rejectBlocked bool
+	// unlinked are pairs of hosts that have no path in built topology.
+	unlinked map[linkKey]struct{}
+
+	portUnreachable bool
+
+	captures []*Capture
+
+	stats     counters
+	linkStats map[linkKey]*counters
+
+	interceptors     []Interceptor
+	linkInterceptors map[linkKey][]Interceptor
+
+	readBuffer  BufferSize
+	writeBuffer BufferSize
+
+	// defaultHost owns sockets bound to the unspecified address.
+	defaultHost *Host
+
+	ephemeralMin  int
+	ephemeralMax  int
+	ephemeralNext int
+
+	initialized bool
+}
+
+// initUnlocked initializes zero value of Net.
+func (n *Net) initUnlocked() {
+	if n.initialized {
+		return
+	}
+	n.initialized = true
+	n.rand = rand.New(rand.NewSource(0))
+	n.owners = map[netip.Addr]*Host{}
+	n.links = map[linkKey]Link{}
+	n.linkStates = map[linkKey]*linkState{}
+	n.peerLinks = map[netip.Addr]Link{}
+	n.linkStats = map[linkKey]*counters{}
+	n.linkInterceptors = map[linkKey][]Interceptor{}
+	n.readBuffer = BufferSize{Bytes: defaultBufferBytes}
+	n.writeBuffer = BufferSize{Bytes: defaultBufferBytes}
+	n.ephemeralMin = defaultEphemeralMin
+	n.ephemeralMax = defaultEphemeralMax
+	n.ephemeralNext = defaultEphemeralMin
+}
+
+// SetPortUnreachable sets whether packets sent to addresses nobody listens on
+// produce ICMP port unreachable errors. Like on Linux, the error is reported
+// only to connected sockets, as ECONNREFUSED on the next read. By default
+// such packets are silently dropped.
+func (n *Net) SetPortUnreachable(enabled bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.portUnreachable = enabled
+}
+
+// SetDefaultBufferSize sets receive and send buffer limits of sockets created
+// afterwards. By default, both buffers are limited to 212992 bytes, like on
+// Linux.
+func (n *Net) SetDefaultBufferSize(read, write BufferSize) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.readBuffer = read
+	n.writeBuffer = write
+}
+
+// now returns current time of the bound clock.
+func (n *Net) now() time.Time {
+	if n.time == nil {
+		return time.Now()
+	}
+	return n.time.Now()
+}
+
+// after runs f once d elapses on the bound clock.
+func (n *Net) after(d time.Duration, f func()) {
+	if n.time == nil {
+		time.AfterFunc(d, f)
+		return
+	}
+	n.time.planAfter(d, func(time.Time) { f() })
+}
+
+// deadline returns notifier that is closed at t on the bound clock. Zero t
+// means no deadline and nil notifier is returned.
+func (n *Net) deadline(t time.Time) notifier {
+	if t.IsZero() {
+		return nil
+	}
+	deadline := make(notifier)
+	if n.time == nil {
+		time.AfterFunc(time.Until(t), func() { close(deadline) })
+		return deadline
+	}
+	n.time.planOrRun(t, func(time.Time) { close(deadline) })
+	return deadline
 }
 
 type packet struct {
-	buf  []byte
+	buf []byte
+	// pool is the pooled buffer of buf, if any.
+	pool *payload
 	addr net.Addr
+	// dst is the destination address from the IP header and ttl is the
+	// remaining time to live.
+	dst *net.UDPAddr
+	ttl int
 }
 
 // PacketConn simulates mesh peer of Net.
 type PacketConn struct {
-	packets chan packet
-	addr    net.Addr
-	net     *Net
+	rx   *buffer
+	addr net.Addr
+	net  *Net
+	host *Host
+	// network is the network of socket, like "udp4".
+	network string
+	// family is the set of address families that socket supports.
+	family family
+
+	reusePort bool
 
 	closedMux sync.Mutex
 	closed    bool
+	// done is closed on Close.
+	done chan struct{}
 
 	mux           sync.Mutex
 	deadline      notifier
 	readDeadline  notifier
 	writeDeadline notifier
-}
+	// tx limits packets that are sent but not delivered yet and tracks
+	// their number and size.
+	tx        BufferSize
+	txPackets int
+	txBytes   int
+	// raddr is the remote address of connected socket.
+	raddr net.Addr
+	// cmsg selects control messages of received packets.
+	cmsg ControlFlags
+	// err is the pending asynchronous error, like ICMP port unreachable.
+	err error
 
-func addrKey(a net.Addr) string {
-	if u, ok := a.(*net.UDPAddr); ok {
-		return "udp/" + u.String()
-	}
-	return a.Network() + "/" + a.String()
+	// groups are keys of joined multicast groups and noLoopback disables
+	// delivery of multicast packets to the sending host, both guarded by
+	// mux of Net.
+	groups     map[netip.Addr]struct{}
+	noLoopback bool
+
+	stats counters
 }
 
-func (c *PacketConn) ok() bool {
-	if c == nil {
-		return false
-	}
+// ok reports whether c is usable, i.e. is not nil, like in the net package.
+func (c *PacketConn) ok() bool { return c != nil }
+
+// isClosed reports whether c is closed.
+func (c *PacketConn) isClosed() bool {
 	c.closedMux.Lock()
 	defer c.closedMux.Unlock()
-	return !c.closed
+	return c.closed
 }
 
-var ErrDeadline = errors.New("deadline")
-
 // ReadFrom reads a packet from the connection,
-// copying the payload into p.
+// copying the payload into p. If p is too small, the rest of packet is
+// discarded.
 func (c *PacketConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 	if !c.ok() {
 		return 0, nil, syscall.EINVAL
 	}
+	var ps [1]packet
+	if _, err := c.read(ps[:]); err != nil {
+		return 0, nil, err
+	}
+	n = copy(p, ps[0].buf)
+	ps[0].release()
+	return n, ps[0].addr, nil
+}
+
+// read receives up to len(ps) packets into ps and returns their number,
+// blocking until there is at least one.
+func (c *PacketConn) read(ps []packet) (int, error) {
+	if c.isClosed() {
+		return 0, c.opError("read", nil, net.ErrClosed)
+	}
 
 	c.mux.Lock()
 	deadline := c.deadline
 	readDeadline := c.readDeadline
 	c.mux.Unlock()
 
-	select {
-	case pp := <-c.packets:
-		return copy(p, pp.buf), pp.addr, nil
-	case <-readDeadline:
-		return 0, nil, ErrDeadline
-	case <-deadline:
-		return 0, nil, ErrDeadline
+	for {
+		if err := c.pendingErr(); err != nil {
+			return 0, c.opError("read", nil, os.NewSyscallError("recvfrom", err))
+		}
+		if n := c.rx.popBatch(ps); n > 0 {
+			return n, nil
+		}
+		select {
+		case <-c.rx.ready:
+		case <-readDeadline:
+			return 0, c.opError("read", nil, ErrDeadline)
+		case <-deadline:
+			return 0, c.opError("read", nil, ErrDeadline)
+		case <-c.done:
+			return 0, c.opError("read", nil, net.ErrClosed)
+		}
 	}
 }
 
 // WriteTo writes a packet with payload p to addr.
+//
+// Like a UDP socket, it never blocks: packets that do not fit into the
+// send or receive buffer are dropped.
 func (c *PacketConn) WriteTo(p []byte, a net.Addr) (n int, err error) {
 	if !c.ok() {
 		return 0, syscall.EINVAL
 	}
+	if err := c.checkUnconnected(a); err != nil {
+		return 0, err
+	}
+	return c.write(p, a, nil)
+}
+
+// write sends packet with payload p to a, using source address and TTL from
+// cm if it is not nil.
+func (c *PacketConn) write(p []byte, a net.Addr, cm *ControlMessage) (int, error) {
+	if _, err := c.writeBatch([]message{{p: p, a: a, cm: cm}}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// message is a packet to send.
+type message struct {
+	p  []byte
+	a  net.Addr
+	cm *ControlMessage
+
+	src, dst *net.UDPAddr
+	addrs    *messageAddrs
+	ttl      int
+	targets  []target
+	chains   [][]Interceptor
+}
+
+// messageAddrs is memory of source and destination addresses of a message,
+// allocated at once. Receiver of the message owns it.
+type messageAddrs struct {
+	src, dst net.UDPAddr
+	ip       [net.IPv6len]byte
+}
+
+// setSrc sets source address to a copy of ip, port and zone.
+func (a *messageAddrs) setSrc(ip net.IP, port int, zone string) *net.UDPAddr {
+	a.src = net.UDPAddr{IP: a.ip[:copy(a.ip[:], ip)], Port: port, Zone: zone}
+	return &a.src
+}
+
+// writeBatch sends messages, locking Net once per stage for all of them. It
+// returns the number of sent messages and the error of the first message
+// that was not sent.
+func (c *PacketConn) writeBatch(ms []message) (int, error) {
+	if len(ms) == 0 {
+		return 0, nil
+	}
+	if err := c.checkWrite(ms[0].a); err != nil {
+		return 0, err
+	}
+	s := scratchPool.Get().(*scratch)
+	defer s.free()
+
+	b := &batch{c: c, n: len(ms), s: s}
+	b.validate(ms)
+
+	nt := c.net
+	b.now = nt.now()
+	nt.mux.Lock()
+	nt.initUnlocked()
+	b.resolveUnlocked(ms[:b.n])
+	nt.mux.Unlock()
+
+	b.intercept(ms[:b.n])
+
+	nt.mux.Lock()
+	b.transmitUnlocked(ms)
+	nt.mux.Unlock()
+
+	b.finish(ms[:b.n])
+	return b.n, b.failed
+}
+
+// checkWrite returns error of write to a, if the socket is closed, has a
+// pending error or exceeded deadline.
+func (c *PacketConn) checkWrite(a net.Addr) error {
+	if c.isClosed() {
+		return c.opError("write", a, net.ErrClosed)
+	}
+	// Only connected sockets have pending errors.
+	if err := c.pendingErr(); err != nil {
+		return c.opError("write", a, os.NewSyscallError("write", err))
+	}
 
 	c.mux.Lock()
 	deadline := c.deadline
@@ -86,54 +354,554 @@ func (c *PacketConn) WriteTo(p []byte, a net.Addr) (n int, err error) {
 	c.mux.Unlock()
 
 	select {
-	case c.net.peers[addrKey(a)].packets <- packet{
-		addr: c.addr,
-		buf:  append([]byte{}, p...),
-	}:
-		return len(p), nil
 	case <-writeDeadline:
-		return 0, ErrDeadline
+		return c.opError("write", a, ErrDeadline)
 	case <-deadline:
-		return 0, ErrDeadline
+		return c.opError("write", a, ErrDeadline)
+	default:
+		return nil
+	}
+}
+
+// batch is the state of writeBatch. Stages are called in order: validate,
+// resolveUnlocked, intercept, transmitUnlocked and finish. Messages are
+// passed to stages instead of being kept in batch, so that they stay on
+// stack of the caller.
+type batch struct {
+	c   *PacketConn
+	s   *scratch
+	now time.Time
+
+	// n is the number of messages before the first failed one, which cuts
+	// the batch, and failed is its error.
+	n        int
+	failed   error
+	drops    []DropReason
+	captures []*Capture
+
+	out      []forwarded
+	sent     []delivery
+	receipts []receipt
+	delayed  []delivery
+}
+
+// fail cuts the batch at message i to a that failed with err.
+func (b *batch) fail(i int, a net.Addr, err error) {
+	b.failed = b.c.opError("write", a, err)
+	b.n = i
+}
+
+// validate checks destinations and TTL of messages and sets their TTL.
+func (b *batch) validate(ms []message) {
+	for i := range ms {
+		m := &ms[i]
+		m.addrs = new(messageAddrs)
+		dst := &m.addrs.dst
+		if !udpAddrTo(dst, m.a) || (isLinkLocal(dst.IP) && dst.Zone == "") {
+			b.fail(i, m.a, syscall.EINVAL)
+			return
+		}
+		if !b.c.family.has(ipFamily(dst.IP)) {
+			b.fail(i, m.a, os.NewSyscallError("sendto", syscall.EAFNOSUPPORT))
+			return
+		}
+		if len(m.p) > maxPayload(dst.IP) {
+			b.fail(i, m.a, os.NewSyscallError("sendto", errMsgSize))
+			return
+		}
+		if m.cm != nil && m.cm.TTL > maxTTL {
+			b.fail(i, m.a, os.NewSyscallError("sendmsg", syscall.EINVAL))
+			return
+		}
+		m.dst = dst
+		m.ttl = defaultTTL
+		if m.cm != nil && m.cm.TTL > 0 {
+			m.ttl = m.cm.TTL
+		}
 	}
 }
 
+// resolveUnlocked selects source addresses of messages, applies the outbound
+// firewall and finds targets and interceptors of messages.
+func (b *batch) resolveUnlocked(ms []message) {
+	for i := range ms {
+		if !b.resolveMessageUnlocked(i, &ms[i]) {
+			break
+		}
+	}
+	b.captures = append([]*Capture(nil), b.c.net.captures...)
+}
+
+// resolveMessageUnlocked is resolveUnlocked of message m with index i. It
+// returns false if the message failed.
+func (b *batch) resolveMessageUnlocked(i int, m *message) bool {
+	c, nt := b.c, b.c.net
+	m.src = c.sourceAddrUnlocked(m.addrs, m.dst)
+	if cm := m.cm; cm != nil && cm.Src != nil {
+		ip := normalizeIP(cm.Src)
+		if !c.host.ownsUnlocked(ip, m.dst.Zone) || ipFamily(ip) != ipFamily(m.dst.IP) {
+			b.fail(i, m.a, os.NewSyscallError("sendmsg", syscall.EINVAL))
+			return false
+		}
+		var zone string
+		if isLinkLocal(ip) {
+			zone = m.dst.Zone
+		}
+		m.src = m.addrs.setSrc(ip, m.src.Port, zone)
+	}
+	if !c.host.allowUnlocked(Outbound, m.src, m.dst, b.now) {
+		b.fail(i, m.a, os.NewSyscallError("sendto", syscall.EPERM))
+		c.drop(DropFirewall)
+		return false
+	}
+	start := len(b.s.targets)
+	b.s.targets = nt.targetsUnlocked(b.s.targets, c, m.src, m.dst)
+	m.targets = b.s.targets[start:]
+	for j, t := range m.targets {
+		if chain := nt.interceptorsUnlocked(m.src.IP, t.to); len(chain) > 0 {
+			if m.chains == nil {
+				m.chains = make([][]Interceptor, len(m.targets))
+			}
+			m.chains[j] = chain
+		}
+	}
+	return true
+}
+
+// intercept passes messages to every target through interceptors, if any,
+// and collects datagrams to transmit.
+func (b *batch) intercept(ms []message) {
+	b.out = b.s.out[:0]
+	for i := range ms {
+		m := &ms[i]
+		for j, t := range m.targets {
+			if m.chains == nil || len(m.chains[j]) == 0 {
+				// Every receiver owns the source address.
+				src := m.src
+				if j > 0 {
+					src = cloneUDPAddr(src)
+				}
+				buf, pool := newPayload(m.p)
+				d := Datagram{Src: src, Dst: m.dst, Payload: buf, Time: b.now}
+				b.out = append(b.out, forwarded{Datagram: d, target: t, msg: i, pool: pool})
+				continue
+			}
+			// Interceptor owns the payload and the addresses, so the payload
+			// is not pooled.
+			d := Datagram{Src: cloneUDPAddr(m.src), Dst: cloneUDPAddr(m.dst), Payload: append([]byte{}, m.p...), Time: b.now}
+			f := intercept(m.chains[j], t, d)
+			if len(f) == 0 {
+				b.drops = append(b.drops, DropIntercept)
+			}
+			for k := range f {
+				f[k].msg = i
+			}
+			b.out = append(b.out, f...)
+		}
+	}
+}
+
+// transmitUnlocked applies links to datagrams. Packets that arrive
+// immediately are resolved to receivers under the same lock, delayed ones
+// are kept for send.
+func (b *batch) transmitUnlocked(ms []message) {
+	b.sent = b.s.sent[:0]
+	for _, f := range b.out {
+		if f.msg >= b.n {
+			// Message failed at the link level.
+			break
+		}
+		b.transmitDatagramUnlocked(f, ms[f.msg])
+	}
+	b.receipts, b.delayed = b.s.receipts[:0], b.sent[:0]
+	for _, d := range b.sent {
+		switch {
+		case d.msg >= b.n:
+			d.packet.release()
+		case d.delay == 0:
+			b.receipts = append(b.receipts, b.c.net.resolveUnlocked(b.c, d))
+		default:
+			b.delayed = append(b.delayed, d)
+		}
+	}
+}
+
+// transmitDatagramUnlocked is transmitUnlocked of f: it applies partitions,
+// NAT and the direct or routed path.
+func (b *batch) transmitDatagramUnlocked(f forwarded, m message) {
+	nt, t := b.c.net, f.target
+	ls := nt.linkStatsUnlocked(f.Src.IP, t.to)
+	ls.sent(1, len(f.Payload))
+	if nt.isBlockedUnlocked(f.Src.IP, t.to) {
+		if nt.rejectBlocked && t.host == nil {
+			b.fail(f.msg, m.a, os.NewSyscallError("sendto", errHostUnreach))
+			return
+		}
+		b.drop(ls, DropPartition)
+		return
+	}
+	p := packet{buf: f.Payload, pool: f.pool, addr: f.Src, dst: f.Dst, ttl: m.ttl}
+	if t.host == nil {
+		src, ok := nt.natOutUnlocked(f.Src, f.Dst, b.now)
+		if !ok {
+			b.drop(ls, DropNAT)
+			return
+		}
+		p.addr = src
+	}
+	if nt.routers > 0 && t.host == nil && b.routeUnlocked(f, m, ls, p) {
+		return
+	}
+	l, s := nt.linkUnlocked(f.Src.IP, t.to)
+	if !l.Fragment && !l.fits(t.to, len(f.Payload)) {
+		b.fail(f.msg, m.a, os.NewSyscallError("sendto", errMsgSize))
+		return
+	}
+	var tsBuf [2]transmission
+	ts, reason := l.transmitFragmented(tsBuf[:0], nt.rand, s, b.now, 0, t.to, p)
+	if s != nil {
+		ls.queued(len(s.slots))
+	}
+	if reason != 0 {
+		b.drop(ls, reason)
+		p.release()
+	}
+	b.schedule(f, ts)
+}
+
+// routeUnlocked transmits p of f from m along the routed path. It returns
+// false if the path is a single hop without drop, which is the direct link.
+func (b *batch) routeUnlocked(f forwarded, m message, ls *counters, p packet) bool {
+	nt := b.c.net
+	hops, ttl, end, err := nt.pathUnlocked(b.c.host, f.Src.IP, f.target.to, p.ttl)
+	if err != nil {
+		b.fail(f.msg, m.a, os.NewSyscallError("sendto", err))
+		return true
+	}
+	if len(hops) == 1 && end == 0 {
+		return false
+	}
+	// Sender knows MTU of the first hop only.
+	if l, _ := nt.linkUnlocked(hops[0].from, hops[0].to); !l.Fragment && !l.fits(hops[0].to, len(f.Payload)) {
+		b.fail(f.msg, m.a, os.NewSyscallError("sendto", errMsgSize))
+		return true
+	}
+	p.ttl = ttl
+	ts, reasons := nt.transmitRoutedUnlocked(b.now, hops, end, p)
+	for _, reason := range reasons {
+		b.drop(ls, reason)
+	}
+	b.schedule(f, ts)
+	return true
+}
+
+// drop counts drop of packet by link with stats ls.
+func (b *batch) drop(ls *counters, reason DropReason) {
+	ls.drop(reason)
+	b.drops = append(b.drops, reason)
+}
+
+// schedule adds deliveries of transmissions ts of f.
+func (b *batch) schedule(f forwarded, ts []transmission) {
+	for _, tr := range ts {
+		tr.delay += f.delay
+		b.sent = append(b.sent, delivery{
+			msg:          f.msg,
+			dst:          f.Dst,
+			at:           b.now.Add(tr.delay),
+			target:       f.target,
+			transmission: tr,
+		})
+	}
+}
+
+// finish counts stats and drops, records captures and delivers packets.
+func (b *batch) finish(ms []message) {
+	c, nt := b.c, b.c.net
+	var bytes int
+	for _, m := range ms {
+		bytes += len(m.p)
+		for _, capture := range b.captures {
+			capture.record(Datagram{Src: m.src, Dst: m.dst, Payload: m.p, Time: b.now})
+		}
+	}
+	c.stats.sent(len(ms), bytes)
+	nt.stats.sent(len(ms), bytes)
+	for _, reason := range b.drops {
+		c.drop(reason)
+	}
+	for _, r := range b.receipts {
+		nt.receive(c, r)
+	}
+	for _, d := range b.delayed {
+		c.send(d)
+	}
+	b.s.out, b.s.sent, b.s.receipts = b.out, b.sent, b.receipts
+}
+
+// sourceAddrUnlocked sets source address of packets sent to dst in a and
+// returns it. The address is a copy, as interceptors and receivers may
+// modify it.
+func (c *PacketConn) sourceAddrUnlocked(a *messageAddrs, dst *net.UDPAddr) *net.UDPAddr {
+	local := c.addr.(*net.UDPAddr)
+	if !local.IP.IsUnspecified() {
+		return a.setSrc(local.IP, local.Port, local.Zone)
+	}
+	src := c.host.sourceAddrUnlocked(dst)
+	return a.setSrc(src.IP, local.Port, src.zone)
+}
+
+// send delivers packet after the transmission delay. Delayed packets occupy
+// the send buffer until delivered.
+func (c *PacketConn) send(d delivery) {
+	if d.delay == 0 {
+		c.net.deliver(c, d)
+		return
+	}
+	size := len(d.packet.buf)
+	if !c.reserve(size) {
+		c.drop(DropWriteBuffer)
+		d.packet.release()
+		return
+	}
+	// Copy, so that d escapes only for delayed packets.
+	later := d
+	c.net.after(later.delay, func() {
+		c.release(size)
+		c.net.deliver(c, later)
+	})
+}
+
+// deliver hands packet sent by the from socket to dst at the moment of
+// arrival.
+//
+// Unicast packet is delivered to the socket bound to dst. If there is no such
+// socket, the packet is dropped and, if enabled, the sender is notified like
+// by ICMP port unreachable. Broadcast and multicast packets are delivered to
+// all matching sockets of the target host. Packets denied by the firewall of
+// the receiving host are dropped first.
+func (n *Net) deliver(from *PacketConn, d delivery) {
+	n.mux.Lock()
+	r := n.resolveUnlocked(from, d)
+	n.mux.Unlock()
+	n.receive(from, r)
+}
+
+// receipt is a delivery resolved to receiving sockets.
+type receipt struct {
+	packet
+	dst *net.UDPAddr
+	// drop is the reason of dropping the packet by firewall or NAT, if any.
+	drop DropReason
+	// group is set for broadcast and multicast packet, which is received by
+	// peers. Unicast packet is received by peer, if any.
+	group       bool
+	peers       []*PacketConn
+	peer        *PacketConn
+	unreachable bool
+}
+
+// resolveUnlocked finds receivers of delivery d, see deliver.
+func (n *Net) resolveUnlocked(from *PacketConn, d delivery) receipt {
+	r := receipt{packet: d.packet, dst: d.dst}
+	src := r.addr.(*net.UDPAddr)
+	n.linkStatsUnlocked(src.IP, d.to).received(1, len(r.buf))
+	// Translated destination is used to find the receiver, while r.dst is
+	// kept to match the sender's connection on refusal.
+	dst, h := r.dst, d.host
+	if h == nil {
+		var ok bool
+		if dst, ok = n.natInUnlocked(src, r.dst, d.at); !ok {
+			r.drop = DropNAT
+			return r
+		}
+		r.packet.dst = dst
+		h = n.owners[scopedKey(dst.IP, dst.Zone)]
+	}
+	switch {
+	case h != nil && !h.allowUnlocked(Inbound, src, dst, d.at):
+		r.drop = DropFirewall
+	case d.host != nil:
+		r.group = true
+		r.peers = d.host.groupPeersUnlocked(from, src, dst)
+	default:
+		r.peer = n.lookupUnlocked(dst, src)
+		r.unreachable = n.portUnreachable
+	}
+	return r
+}
+
+// receive hands packet of r to its receivers.
+func (n *Net) receive(from *PacketConn, r receipt) {
+	p := r.packet
+	switch {
+	case r.drop != 0:
+		from.drop(r.drop)
+		p.release()
+	case r.group:
+		if len(r.peers) == 0 {
+			p.release()
+		}
+		for i, peer := range r.peers {
+			q := p
+			if i < len(r.peers)-1 {
+				q = p.clone()
+			}
+			if !peer.deliver(q) {
+				q.release()
+			}
+		}
+	case r.peer == nil || !r.peer.deliver(p):
+		// Peer may be closed concurrently.
+		p.release()
+		from.drop(DropNoListener)
+		if r.unreachable {
+			from.refuse(r.dst)
+		}
+	}
+}
+
+// refuse handles port unreachable error for a packet sent to dst. Like Linux,
+// only connected sockets report the error, as ECONNREFUSED on the next read.
+func (c *PacketConn) refuse(dst *net.UDPAddr) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.raddr == nil || addrKey(c.raddr) != addrKey(dst) {
+		return
+	}
+	c.err = errConnRefused
+	// Wake up blocked reader.
+	c.rx.signal()
+}
+
+// pendingErr returns and clears asynchronous error of the connection.
+func (c *PacketConn) pendingErr() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	err := c.err
+	c.err = nil
+	return err
+}
+
+// reserve occupies send buffer with a packet of the given size. It returns
+// false if the packet does not fit.
+func (c *PacketConn) reserve(size int) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if !c.tx.fits(c.txPackets, c.txBytes, size) {
+		return false
+	}
+	c.txPackets++
+	c.txBytes += size
+	return true
+}
+
+// release frees send buffer space occupied by reserve.
+func (c *PacketConn) release(size int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.txPackets--
+	c.txBytes -= size
+}
+
+// deliver puts packet to the receive buffer. It never blocks and drops the
+// packet if the buffer is full. It returns false if connection is closed
+// and the packet was not accepted.
+func (c *PacketConn) deliver(p packet) bool {
+	if c.isClosed() {
+		return false
+	}
+	queued, ok := c.rx.push(p)
+	if !ok {
+		c.drop(DropReadBuffer)
+		p.release()
+		return true
+	}
+	c.stats.receivedQueued(len(p.buf), queued)
+	c.net.stats.received(1, len(p.buf))
+	return true
+}
+
 func (c *PacketConn) LocalAddr() net.Addr { return c.addr }
 
-// Close closes the connection.
+// Close closes the connection. Blocked ReadFrom is unblocked and, like any
+// later operation, fails with net.ErrClosed.
 func (c *PacketConn) Close() error {
 	if !c.ok() {
 		return syscall.EINVAL
 	}
 	c.closedMux.Lock()
-	defer c.closedMux.Unlock()
 	if c.closed {
-		return syscall.EINVAL
+		c.closedMux.Unlock()
+		return c.opError("close", nil, net.ErrClosed)
 	}
 	c.closed = true
-	close(c.packets)
+	close(c.done)
+	c.closedMux.Unlock()
+
+	// Free the address, so packets sent to it are not delivered and it can
+	// be bound again.
+	n := c.net
+	n.mux.Lock()
+	n.unbindUnlocked(c)
+	n.mux.Unlock()
 	return nil
 }
 
-type notifier chan struct{}
+// SetReadBuffer sets the size of the receive buffer in bytes.
+func (c *PacketConn) SetReadBuffer(bytes int) error {
+	return c.SetReadBufferSize(BufferSize{Bytes: bytes})
+}
 
-func simpleDeadline(t time.Time) notifier {
-	deadline := make(notifier)
-	go func() {
-		now := time.Now()
-		<-time.After(t.Sub(now))
-		close(deadline)
-	}()
+// SetReadBufferSize sets the receive buffer limits. Packets that are already
+// buffered are kept.
+func (c *PacketConn) SetReadBufferSize(s BufferSize) error {
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	c.rx.resize(s)
+	return nil
+}
 
-	return deadline
+// SetWriteBuffer sets the size of the send buffer in bytes.
+func (c *PacketConn) SetWriteBuffer(bytes int) error {
+	return c.SetWriteBufferSize(BufferSize{Bytes: bytes})
+}
+
+// SetWriteBufferSize sets the send buffer limits. The send buffer holds
+// packets that are delayed by links until they are delivered.
+func (c *PacketConn) SetWriteBufferSize(s BufferSize) error {
+	if !c.ok() {
+		return syscall.EINVAL
+	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	c.mux.Lock()
+	c.tx = s
+	c.mux.Unlock()
+	return nil
 }
 
+type notifier chan struct{}
+
 func (c *PacketConn) SetDeadline(t time.Time) error {
 	if !c.ok() {
 		return syscall.EINVAL
 	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	deadline := c.net.deadline(t)
 	c.mux.Lock()
-	c.deadline = simpleDeadline(t)
+	c.deadline = deadline
 	c.mux.Unlock()
 	return nil
 }
@@ -142,8 +910,12 @@ func (c *PacketConn) SetReadDeadline(t time.Time) error {
 	if !c.ok() {
 		return syscall.EINVAL
 	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	deadline := c.net.deadline(t)
 	c.mux.Lock()
-	c.readDeadline = simpleDeadline(t)
+	c.readDeadline = deadline
 	c.mux.Unlock()
 	return nil
 }
@@ -152,8 +924,12 @@ func (c *PacketConn) SetWriteDeadline(t time.Time) error {
 	if !c.ok() {
 		return syscall.EINVAL
 	}
+	if c.isClosed() {
+		return c.opError("set", nil, net.ErrClosed)
+	}
+	deadline := c.net.deadline(t)
 	c.mux.Lock()
-	c.writeDeadline = simpleDeadline(t)
+	c.writeDeadline = deadline
 	c.mux.Unlock()
 	return nil
 }
@@ -167,63 +943,93 @@ func (n NetAddr) Network() string { return n.Net }
 func (n NetAddr) String() string  { return n.Address }
 
 // ResolveUDPAddr returns an address of UDP end point.
+//
+// The network must be "udp", "udp4" or "udp6", and address family must match
+// the network. IPv4 addresses, including IPv4-mapped IPv6 ones, are returned
+// in 4-byte representation. Empty host results in nil IP.
+//
+// Like net.ResolveUDPAddr, it returns net.UnknownNetworkError for other
+// networks and *net.AddrError for bad addresses.
 func (n *Net) ResolveUDPAddr(network, address string) (*net.UDPAddr, error) {
-	a := &net.UDPAddr{
-		Port: 0,
-		IP:   net.IPv4(127, 0, 0, 1),
+	if network != "udp4" && network != "udp" && network != "udp6" {
+		return nil, net.UnknownNetworkError(network)
 	}
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		return nil, err
 	}
+	a := &net.UDPAddr{}
+	if a.Port, err = strconv.Atoi(port); err != nil || a.Port < 0 || a.Port > 65535 {
+		return nil, &net.AddrError{Err: "invalid port", Addr: address}
+	}
+	if host == "" {
+		return a, nil
+	}
+	host, a.Zone = splitZone(host)
 	if a.IP = net.ParseIP(host); a.IP == nil {
 		// Probably we should use virtual DNS here.
-		return nil, errors.New("bad IP")
+		return nil, &net.AddrError{Err: "invalid IP address", Addr: host}
 	}
-	if a.Port, err = strconv.Atoi(port); err != nil {
-		return nil, err
+	a.IP = normalizeIP(a.IP)
+	switch f := ipFamily(a.IP); {
+	case network == "udp4" && f != familyIPv4,
+		network == "udp6" && f != familyIPv6:
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: address}
+	case a.Zone != "" && f != familyIPv6:
+		return nil, &net.AddrError{Err: "invalid zone", Addr: address}
 	}
 	return a, nil
 }
 
-// ListenPacket announces on the local network address.
+// ListenPacket announces on the local network address. If the port is zero,
+// an ephemeral port is allocated.
 func (n *Net) ListenPacket(network, address string) (net.PacketConn, error) {
-	if network != "udp4" && network != "udp" && network != "udp6" {
-		return nil, errors.New("bad net")
-	}
-	a, err := n.ResolveUDPAddr(network, address)
+	return n.ListenPacketConfig(ListenConfig{}, network, address)
+}
+
+// ListenPacketConfig is like ListenPacket but with options.
+//
+// The socket belongs to the host that owns the address. If no host owns the
+// address, a single-address host is added. Sockets bound to the unspecified
+// or multicast address belong to the default host, see Net.SetDefaultHost,
+// use Host to bind them on another one.
+func (n *Net) ListenPacketConfig(lc ListenConfig, network, address string) (net.PacketConn, error) {
+	a, err := n.resolveListen(network, address)
 	if err != nil {
-		return nil, err
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
 	}
-	pc := &PacketConn{
-		net:     n,
-		addr:    a,
-		packets: make(chan packet, 10),
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	var h *Host
+	if a.IP.IsUnspecified() || a.IP.IsMulticast() {
+		h = n.defaultHostUnlocked()
+	} else {
+		h = n.hostUnlocked(a.IP, a.Zone)
+	}
+	pc, err := h.listenUnlocked(lc, network, a)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: a, Err: err}
 	}
-	n.peers[addrKey(a)] = pc
 	return pc, nil
 }
 
-// NAT implements facility for Network Address Translation simulation.
-//
-// Basic example:
-// 	[ A ] <-----> [ NAT1 ] <-----> [ NAT2 ] <-----> [ B ]
-//      IPa              IPa'     IPb'             IPb
-//
-// 	1) A sends packet P with dst = IPb'
-//  2) NAT1 receives packet P and changes it's src to IPa',
-//   sending it to NAT2 from IPa'.
-//  3) NAT2 receives packet P from IPa' to IPb', does a lookup to
-//   NAT translation table and finds association IPb' <-> IPb.
-//   Then it sends packet P to B.
-//  4) B receives packet P from NAT2, observing that it has src = IPa'.
-//
-//  Now B can repeat steps 1-4 and send packet back.
-//
-//  IPa  = 10.5.0.1:30000
-//  IPa' = 83.30.100.1:23100
-//  IPb' = 91.10.100.1:13000
-//  IPb  = 10.1.0.1:20000
-type NAT struct {
-	// TODO(ar): implement
+// resolveListen resolves local address to listen on. Empty host is resolved
+// to the unspecified address.
+func (n *Net) resolveListen(network, address string) (*net.UDPAddr, error) {
+	a, err := n.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if a.IP == nil {
+		a.IP = net.IPv6unspecified
+		if network == "udp4" {
+			a.IP = net.IPv4zero.To4()
+		}
+	}
+	if isLinkLocal(a.IP) && a.Zone == "" {
+		return nil, &net.AddrError{Err: "link-local address requires a zone", Addr: address}
+	}
+	return a, nil
 }
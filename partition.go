@@ -0,0 +1,100 @@
+package neo
+
+import (
+	"net"
+	"time"
+)
+
+// Partition splits the network into groups of peers, so traffic between
+// peers of different groups vanishes. Traffic within a group and traffic of
+// peers outside all groups is not affected.
+//
+// Partitions accumulate until Heal is called.
+func (n *Net) Partition(groups ...[]net.IP) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	for i, from := range groups {
+		for j, to := range groups {
+			if i != j {
+				n.blockUnlocked(from, to)
+			}
+		}
+	}
+}
+
+// PartitionOneWay makes traffic from one group of peers to another vanish,
+// while traffic in the opposite direction is still delivered.
+func (n *Net) PartitionOneWay(from, to []net.IP) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.blockUnlocked(from, to)
+}
+
+// Heal removes all partitions.
+func (n *Net) Heal() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.blocked = nil
+}
+
+// SetPartitionReject sets whether writes across partitions are rejected with
+// EHOSTUNREACH instead of silently vanishing.
+func (n *Net) SetPartitionReject(reject bool) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.rejectBlocked = reject
+}
+
+// At runs f at the given time of the bound clock, so changes like Partition
+// and Heal can be scripted on the simulation timeline. If t is not after the
+// current time, f is run immediately.
+//
+// Note that f runs under the lock of the bound Time and must not call its
+// methods.
+func (n *Net) At(t time.Time, f func()) {
+	if n.time == nil {
+		time.AfterFunc(time.Until(t), f)
+		return
+	}
+	n.time.planOrRun(t, func(time.Time) { f() })
+}
+
+func (n *Net) blockUnlocked(from, to []net.IP) {
+	if n.blocked == nil {
+		n.blocked = map[linkKey]struct{}{}
+	}
+	for _, a := range from {
+		for _, b := range to {
+			n.blocked[linkKey{from: ipKey(a), to: ipKey(b)}] = struct{}{}
+		}
+	}
+}
+
+// unlink makes traffic from one IP to another vanish like partition, because
+// there is no path between them. Unlike partitions, Heal does not remove it.
+func (n *Net) unlink(from, to net.IP) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.unlinked == nil {
+		n.unlinked = map[linkKey]struct{}{}
+	}
+	n.unlinked[linkKey{from: ipKey(from), to: ipKey(to)}] = struct{}{}
+}
+
+// isBlockedUnlocked reports whether path between from and to crosses a
+// partition or does not exist.
+func (n *Net) isBlockedUnlocked(from, to net.IP) bool {
+	k := linkKey{from: ipKey(from), to: ipKey(to)}
+	if _, ok := n.unlinked[k]; ok {
+		return true
+	}
+	_, ok := n.blocked[k]
+	return ok
+}
@@ -0,0 +1,63 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNet_Partition(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+
+	a := listen(t, nt, "10.0.0.1:123")
+	b := listen(t, nt, "10.0.0.2:123")
+	c := listen(t, nt, "10.0.0.3:123")
+
+	// delivered reports whether packet from src reaches dst.
+	delivered := func(src, dst *PacketConn) bool {
+		t.Helper()
+		if _, err := src.WriteTo([]byte("hello"), dst.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		_, ok := dst.rx.pop()
+		return ok
+	}
+
+	nt.At(now.Add(time.Second), func() {
+		nt.Partition([]net.IP{net.ParseIP("10.0.0.1")}, []net.IP{net.ParseIP("10.0.0.2")})
+	})
+	nt.At(now.Add(2*time.Second), nt.Heal)
+
+	if !delivered(a, b) || !delivered(b, a) {
+		t.Error("partitioned before scheduled time")
+	}
+
+	sim.Travel(time.Second)
+	if delivered(a, b) || delivered(b, a) {
+		t.Error("partition not applied")
+	}
+	if !delivered(a, c) || !delivered(c, b) {
+		t.Error("peer outside of partition affected")
+	}
+
+	sim.Travel(time.Second)
+	if !delivered(a, b) || !delivered(b, a) {
+		t.Error("partition not healed")
+	}
+
+	nt.PartitionOneWay([]net.IP{net.ParseIP("10.0.0.1")}, []net.IP{net.ParseIP("10.0.0.2")})
+	if delivered(a, b) {
+		t.Error("one-way partition not applied")
+	}
+	if !delivered(b, a) {
+		t.Error("one-way partition blocks reverse direction")
+	}
+
+	nt.SetPartitionReject(true)
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); !errors.Is(err, errHostUnreach) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
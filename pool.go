@@ -0,0 +1,82 @@
+package neo
+
+import (
+	"net"
+	"sync"
+)
+
+// pooledSize is the size of pooled payload buffers, which is enough for
+// packets of typical MTU. Larger payloads are not pooled.
+const pooledSize = 2048
+
+// payload is a pooled packet buffer.
+type payload struct {
+	buf []byte
+}
+
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		return &payload{buf: make([]byte, pooledSize)}
+	},
+}
+
+// newPayload returns copy of p and its pooled buffer, if any. The buffer
+// must be released after the last use of the copy.
+func newPayload(p []byte) ([]byte, *payload) {
+	if len(p) > pooledSize {
+		return append([]byte{}, p...), nil
+	}
+	b := payloadPool.Get().(*payload)
+	return b.buf[:copy(b.buf, p)], b
+}
+
+// release returns buffer of p to the pool. The packet must not be used
+// after it.
+func (p packet) release() {
+	if p.pool != nil {
+		payloadPool.Put(p.pool)
+	}
+}
+
+// clone returns copy of p with its own buffer and source address.
+func (p packet) clone() packet {
+	p.buf, p.pool = newPayload(p.buf)
+	if a, ok := p.addr.(*net.UDPAddr); ok {
+		p.addr = cloneUDPAddr(a)
+	}
+	return p
+}
+
+// scratch is reusable memory of writeBatch and ReadBatch.
+type scratch struct {
+	packets  []packet
+	targets  []target
+	out      []forwarded
+	sent     []delivery
+	receipts []receipt
+}
+
+var scratchPool = sync.Pool{
+	New: func() interface{} { return &scratch{} },
+}
+
+// free clears s, so it does not retain packets, and puts it to the pool.
+func (s *scratch) free() {
+	for i := range s.packets[:cap(s.packets)] {
+		s.packets[i] = packet{}
+	}
+	for i := range s.targets {
+		s.targets[i] = target{}
+	}
+	for i := range s.out {
+		s.out[i] = forwarded{}
+	}
+	for i := range s.sent {
+		s.sent[i] = delivery{}
+	}
+	for i := range s.receipts {
+		s.receipts[i] = receipt{}
+	}
+	s.targets, s.out, s.sent, s.receipts = s.targets[:0], s.out[:0], s.sent[:0], s.receipts[:0]
+	scratchPool.Put(s)
+}
@@ -0,0 +1,199 @@
+package neo
+
+import (
+	"errors"
+	"hash/fnv"
+	"net"
+)
+
+// Default range of ephemeral ports, same as net.ipv4.ip_local_port_range of
+// Linux.
+const (
+	defaultEphemeralMin = 32768
+	defaultEphemeralMax = 60999
+)
+
+// ListenConfig contains options for listening to an address.
+type ListenConfig struct {
+	// ReusePort allows several sockets to bind the same address, like
+	// SO_REUSEPORT. All sockets must set it. Incoming packets are distributed
+	// across the sockets by hash of the source address, so packets from one
+	// peer always reach the same socket.
+	ReusePort bool
+}
+
+// SetEphemeralPorts sets range of ports allocated when binding to port zero.
+// The default range is [32768, 60999], like on Linux. The range must be
+// within [1, 65535].
+func (n *Net) SetEphemeralPorts(min, max int) error {
+	if min < 1 || max > 0xffff || min > max {
+		return errors.New("bad ephemeral port range")
+	}
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.initUnlocked()
+
+	n.ephemeralMin = min
+	n.ephemeralMax = max
+	n.ephemeralNext = min
+	return nil
+}
+
+// ephemeralPortUnlocked allocates port that is free for socket c on its host.
+// It returns false if all ports of the ephemeral range are in use.
+func (n *Net) ephemeralPortUnlocked(c *PacketConn) (int, bool) {
+	size := n.ephemeralMax - n.ephemeralMin + 1
+	for i := 0; i < size; i++ {
+		port := n.ephemeralNext
+		n.ephemeralNext++
+		if n.ephemeralNext > n.ephemeralMax {
+			n.ephemeralNext = n.ephemeralMin
+		}
+		if len(conflicting(c.host.boundUnlocked(port), c)) == 0 {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// boundUnlocked returns open sockets bound to port of h, forgetting closed
+// ones.
+func (h *Host) boundUnlocked(port int) []*PacketConn {
+	bound := h.sockets[port][:0]
+	for _, c := range h.sockets[port] {
+		if !c.isClosed() {
+			bound = append(bound, c)
+		}
+	}
+	if len(bound) == 0 {
+		delete(h.sockets, port)
+		return nil
+	}
+	h.sockets[port] = bound
+	return bound
+}
+
+// conflicting returns sockets of bound that prevent binding c to the same
+// port: ones bound to the same address and ones bound to the unspecified
+// address of overlapping family, like 0.0.0.0 and 10.0.0.1.
+func conflicting(bound []*PacketConn, c *PacketConn) []*PacketConn {
+	var out []*PacketConn
+	for _, b := range bound {
+		if b.overlaps(c) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// overlaps reports whether c and o can receive the same packets.
+func (c *PacketConn) overlaps(o *PacketConn) bool {
+	a, b := c.addr.(*net.UDPAddr), o.addr.(*net.UDPAddr)
+	switch {
+	case a.IP.IsUnspecified():
+		return c.family.has(o.family)
+	case b.IP.IsUnspecified():
+		return o.family.has(c.family)
+	default:
+		return scopedKey(a.IP, a.Zone) == scopedKey(b.IP, b.Zone)
+	}
+}
+
+// connectedFrom reports whether c is connected to src. Unconnected sockets
+// receive packets from any source, connected ones only from the remote
+// address.
+func (c *PacketConn) connectedFrom(src *net.UDPAddr) (connected, from bool) {
+	c.mux.Lock()
+	raddr := c.raddr
+	c.mux.Unlock()
+
+	if raddr == nil {
+		return false, true
+	}
+	return true, addrKey(raddr) == addrKey(src)
+}
+
+// accepts reports whether c receives packets sent to dst on its port.
+func (c *PacketConn) accepts(dst *net.UDPAddr) bool {
+	a := c.addr.(*net.UDPAddr)
+	if a.IP.IsUnspecified() {
+		return c.family.has(ipFamily(dst.IP))
+	}
+	return scopedKey(a.IP, a.Zone) == scopedKey(dst.IP, dst.Zone)
+}
+
+// bindUnlocked registers c on its host, allocating ephemeral port if needed.
+func (n *Net) bindUnlocked(c *PacketConn) error {
+	h := c.host
+	a := c.addr.(*net.UDPAddr)
+	if a.Port == 0 {
+		port, ok := n.ephemeralPortUnlocked(c)
+		if !ok {
+			return errAddrInUse
+		}
+		a.Port = port
+	}
+	for _, b := range conflicting(h.boundUnlocked(a.Port), c) {
+		if !c.reusePort || !b.reusePort || addrKey(b.addr) != addrKey(a) || b.family != c.family {
+			return errAddrInUse
+		}
+	}
+	h.sockets[a.Port] = append(h.sockets[a.Port], c)
+	return nil
+}
+
+// unbindUnlocked unregisters c from its host and leaves multicast groups.
+func (n *Net) unbindUnlocked(c *PacketConn) {
+	h := c.host
+	port := c.addr.(*net.UDPAddr).Port
+	bound := h.sockets[port][:0]
+	for _, b := range h.sockets[port] {
+		if b != c {
+			bound = append(bound, b)
+		}
+	}
+	if len(bound) == 0 {
+		delete(h.sockets, port)
+	} else {
+		h.sockets[port] = bound
+	}
+	c.groups = nil
+}
+
+// lookupUnlocked returns socket that receives packets sent from src to dst,
+// or nil if there is none. Sockets connected to src are preferred over
+// sockets bound to dst, which are preferred over sockets bound to the
+// unspecified address.
+func (n *Net) lookupUnlocked(dst, src *net.UDPAddr) *PacketConn {
+	h := n.owners[scopedKey(dst.IP, dst.Zone)]
+	if h == nil {
+		return nil
+	}
+	var exact, wildcard []*PacketConn
+	for _, c := range h.boundUnlocked(dst.Port) {
+		connected, from := c.connectedFrom(src)
+		switch {
+		case !c.accepts(dst) || !from:
+		case connected:
+			return c
+		case c.addr.(*net.UDPAddr).IP.IsUnspecified():
+			wildcard = append(wildcard, c)
+		default:
+			exact = append(exact, c)
+		}
+	}
+	if len(exact) == 0 {
+		exact = wildcard
+	}
+	switch len(exact) {
+	case 0:
+		return nil
+	case 1:
+		return exact[0]
+	default:
+		f := fnv.New32a()
+		_, _ = f.Write(src.IP.To16())
+		_, _ = f.Write([]byte{byte(src.Port >> 8), byte(src.Port)})
+		return exact[f.Sum32()%uint32(len(exact))]
+	}
+}
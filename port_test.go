@@ -0,0 +1,98 @@
+package neo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNet_EphemeralPort(t *testing.T) {
+	nt := NewNet(nil)
+	for _, r := range [][2]int{{0, 10}, {2000, 1000}, {60000, 70000}} {
+		if err := nt.SetEphemeralPorts(r[0], r[1]); err == nil {
+			t.Errorf("%v: expected error", r)
+		}
+	}
+	if err := nt.SetEphemeralPorts(1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+
+	a := listen(t, nt, "10.0.0.1:0")
+	b := listen(t, nt, "10.0.0.1:0")
+	if a.LocalAddr().String() != "10.0.0.1:1000" || b.LocalAddr().String() != "10.0.0.1:1001" {
+		t.Errorf("unexpected addresses: %s, %s", a.LocalAddr(), b.LocalAddr())
+	}
+	if _, err := nt.ListenPacket("udp", "10.0.0.1:0"); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Ports are allocated per address.
+	listen(t, nt, "10.0.0.2:0")
+
+	// Port of the closed socket can be reused.
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c := listen(t, nt, "10.0.0.1:0"); c.LocalAddr().String() != "10.0.0.1:1000" {
+		t.Errorf("unexpected address: %s", c.LocalAddr())
+	}
+}
+
+func TestNet_AddrInUse(t *testing.T) {
+	nt := NewNet(nil)
+	a := listen(t, nt, "10.0.0.1:123")
+	if _, err := nt.ListenPacket("udp", "10.0.0.1:123"); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := nt.ListenPacketConfig(ListenConfig{ReusePort: true}, "udp", "10.0.0.1:123"); !errors.Is(err, errAddrInUse) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	listen(t, nt, "10.0.0.1:123")
+}
+
+func TestNet_ReusePort(t *testing.T) {
+	nt := NewNet(nil)
+	lc := ListenConfig{ReusePort: true}
+
+	var servers []*PacketConn
+	for i := 0; i < 2; i++ {
+		c, err := nt.ListenPacketConfig(lc, "udp", "10.0.0.1:53")
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, c.(*PacketConn))
+	}
+	dst := servers[0].LocalAddr()
+
+	perServer := make([]int, len(servers))
+	for i := 0; i < 10; i++ {
+		client := listen(t, nt, "10.0.1.1:0")
+		for j := 0; j < 3; j++ {
+			if _, err := client.WriteTo([]byte("hello"), dst); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var received int
+		for k, s := range servers {
+			if n := s.rx.len(); n != 0 && n != 3 {
+				t.Errorf("packets from one client spread across sockets")
+			}
+			received += s.rx.len()
+			perServer[k] += s.rx.len()
+			for s.rx.len() > 0 {
+				s.rx.pop()
+			}
+		}
+		if received != 3 {
+			t.Errorf("received %d packets, expected 3", received)
+		}
+	}
+
+	for k, n := range perServer {
+		if n == 0 {
+			t.Errorf("socket %d received nothing", k)
+		}
+	}
+}
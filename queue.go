@@ -0,0 +1,139 @@
+package neo
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// queueWeight is the weight of the current queue length in the average
+// queue length, as recommended for RED.
+const queueWeight = 0.002
+
+// Queue is a discipline of the bottleneck queue in front of a link with
+// limited bandwidth.
+type Queue interface {
+	// Drop reports whether a packet arriving to the queue that already holds
+	// n packets should be dropped. The avg is the average number of queued
+	// packets, the exponentially weighted moving average of n.
+	Drop(r *rand.Rand, n int, avg float64) bool
+}
+
+// TailDrop drops arriving packets when the queue holds Limit packets.
+type TailDrop struct {
+	Limit int
+}
+
+// Drop implements Queue.
+func (q TailDrop) Drop(_ *rand.Rand, n int, _ float64) bool { return n >= q.Limit }
+
+// RED implements Random Early Detection. Arriving packets are dropped with
+// probability growing linearly from zero at Min average queued packets to P
+// at Max, and always when the average is Max packets or more.
+//
+// Like in the original RED, the average is updated on every arrival with
+// weight 0.002 and decays while the queue is idle, so short bursts pass and
+// only persistent queues are dropped from. The drop probability does not
+// grow with the count of packets since the last drop.
+type RED struct {
+	Min int
+	Max int
+	P   float64
+}
+
+// Drop implements Queue.
+func (q RED) Drop(r *rand.Rand, _ int, avg float64) bool {
+	switch {
+	case avg < float64(q.Min):
+		return false
+	case avg >= float64(q.Max):
+		return true
+	default:
+		return chance(r, q.P*(avg-float64(q.Min))/float64(q.Max-q.Min))
+	}
+}
+
+// linkState is the mutable state of a link bottleneck queue.
+type linkState struct {
+	// slots are serialization intervals of queued packets, in ascending
+	// order.
+	slots []slot
+	// avg is the average number of queued packets and idle is the time
+	// the queue gets empty.
+	avg  float64
+	idle time.Time
+}
+
+// slot is the interval when a queued packet is serialized.
+type slot struct {
+	arrival, start, finish time.Time
+}
+
+// enqueue puts packet of the given size that arrives to the queue at time
+// at to the queue and returns its serialization delay including time spent
+// waiting in the queue. It returns false if the packet is dropped by the
+// queue discipline.
+//
+// Packets of routed paths are enqueued when sent, before they travel the
+// previous hops, so they may arrive out of order, but never before now.
+// Packet waits only for packets that arrived before it and takes the first
+// gap between packets that arrive later.
+func (s *linkState) enqueue(l Link, r *rand.Rand, now, at time.Time, size int) (time.Duration, bool) {
+	if l.Bandwidth <= 0 {
+		return 0, true
+	}
+
+	// Forget packets that are already on the wire.
+	i := 0
+	for i < len(s.slots) && !s.slots[i].finish.After(now) {
+		i++
+	}
+	// Shift in place, so the backing array is reused.
+	s.slots = s.slots[:copy(s.slots, s.slots[i:])]
+
+	start, queued := at, 0
+	for _, q := range s.slots {
+		if !q.arrival.After(at) && q.finish.After(at) {
+			queued++
+			if q.finish.After(start) {
+				start = q.finish
+			}
+		}
+	}
+	d := time.Duration(int64(size) * int64(time.Second) / int64(l.Bandwidth))
+	s.average(queued, at, d)
+	if l.Queue != nil && l.Queue.Drop(r, queued, s.avg) {
+		return 0, false
+	}
+
+	j := 0
+	for ; j < len(s.slots); j++ {
+		q := s.slots[j]
+		if !q.finish.After(start) {
+			continue
+		}
+		if !start.Add(d).After(q.start) {
+			break
+		}
+		start = q.finish
+	}
+	s.slots = append(s.slots, slot{})
+	copy(s.slots[j+1:], s.slots[j:])
+	s.slots[j] = slot{arrival: at, start: start, finish: start.Add(d)}
+	if start.Add(d).After(s.idle) {
+		s.idle = start.Add(d)
+	}
+
+	return start.Add(d).Sub(at), true
+}
+
+// average updates the average queue length with n packets queued at packet
+// arrival at. If the queue is idle, the average decays as if packets that
+// take d to serialize arrived to the empty queue all the idle time.
+func (s *linkState) average(n int, at time.Time, d time.Duration) {
+	if n == 0 && at.After(s.idle) && d > 0 {
+		m := float64(at.Sub(s.idle)) / float64(d)
+		s.avg *= math.Pow(1-queueWeight, m)
+	}
+	s.avg += queueWeight * (float64(n) - s.avg)
+}
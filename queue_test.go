@@ -0,0 +1,102 @@
+package neo
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestNet_Bandwidth(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	nt.SetDefaultLink(Link{
+		Latency:   FixedLatency(10 * time.Millisecond),
+		Bandwidth: 1000,
+		Queue:     TailDrop{Limit: 3},
+	})
+
+	left, err := nt.ListenPacket("udp", "10.0.0.1:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := nt.ListenPacket("udp", "10.0.0.2:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each packet takes 100ms to serialize, so the queue holds three
+	// packets and the fourth one is dropped.
+	for i := 0; i < 4; i++ {
+		if _, err = right.WriteTo(make([]byte, 100), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rx := left.(*PacketConn).rx
+	if rx.len() != 0 {
+		t.Fatal("unexpected delivery")
+	}
+	sim.Travel(10 * time.Millisecond)
+	for i, expected := range []int{0, 1, 2, 3, 3} {
+		if got := rx.len(); got != expected {
+			t.Errorf("step %d: got %d packets, expected %d", i, got, expected)
+		}
+		sim.Travel(100 * time.Millisecond)
+	}
+	if drops := right.(*PacketConn).Stats().Drops[DropQueue]; drops != 1 {
+		t.Errorf("got %d queue drops, expected 1", drops)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range []struct {
+		q    Queue
+		n    int
+		avg  float64
+		drop bool
+	}{
+		{TailDrop{Limit: 2}, 1, 5, false},
+		{TailDrop{Limit: 2}, 2, 0, true},
+		{RED{Min: 2, Max: 4, P: 1}, 5, 1, false},
+		{RED{Min: 2, Max: 4, P: 0}, 5, 3, false},
+		{RED{Min: 2, Max: 4, P: 0}, 0, 4, true},
+	} {
+		if drop := tt.q.Drop(r, tt.n, tt.avg); drop != tt.drop {
+			t.Errorf("%#v with %d packets, %v on average: got %v, expected %v", tt.q, tt.n, tt.avg, drop, tt.drop)
+		}
+	}
+}
+
+func TestLinkState_RED(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	// Packet of 10 bytes takes 10ms to serialize.
+	l := Link{Bandwidth: 1000, Queue: RED{Min: 2, Max: 4, P: 1}}
+	s := &linkState{}
+
+	// Burst passes, as the average queue is still short.
+	for i := 0; i < 10; i++ {
+		if _, ok := s.enqueue(l, r, now, now, 10); !ok {
+			t.Fatalf("packet %d of burst dropped", i)
+		}
+	}
+
+	// Persistent queue of 10 packets is dropped from.
+	var dropped bool
+	for i := 0; i < 1000 && !dropped; i++ {
+		now = now.Add(10 * time.Millisecond)
+		_, ok := s.enqueue(l, r, now, now, 10)
+		dropped = !ok
+	}
+	if !dropped {
+		t.Fatalf("no drops with average %f", s.avg)
+	}
+
+	// Average decays while the queue is idle.
+	now = now.Add(time.Hour)
+	if _, ok := s.enqueue(l, r, now, now, 10); !ok || s.avg > 0.1 {
+		t.Errorf("got average %f after idle, dropped: %v", s.avg, !ok)
+	}
+}
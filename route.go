@@ -0,0 +1,236 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Route is an entry of host routing table.
+type Route struct {
+	// Dst is the destination network, nil means default route.
+	Dst *net.IPNet
+	// Gateway is the next hop, which must be in a subnet of the host.
+	Gateway net.IP
+}
+
+// hop is a link between adjacent nodes of a routed path.
+type hop struct {
+	from, to net.IP
+}
+
+// AddRouter is like AddHost but adds host that forwards packets between its
+// subnets according to its routing table.
+//
+// Once Net has a router, it is no longer a flat mesh. Hosts reach directly
+// only addresses of their subnets and the rest via routes, see
+// Host.AddRoute, so hosts without subnets and routes are isolated. Every
+// router on the path decrements TTL of the packet and drops it at zero.
+//
+// The whole path of a packet is resolved when it is sent: routes,
+// partitions and links of every hop are taken at that moment, even for
+// hops the packet reaches later. Changing them does not affect packets
+// already in flight.
+func (n *Net) AddRouter(addrs ...string) (*Host, error) {
+	return n.addHost(true, addrs)
+}
+
+// AddRoute adds r to the routing table of the host, replacing the route to
+// the same destination, if any. Routes are used only if Net has routers,
+// see Net.AddRouter.
+func (h *Host) AddRoute(r Route) error {
+	r, err := h.checkRoute(r)
+	if err != nil {
+		return err
+	}
+	h.addRoute(r)
+	return nil
+}
+
+// checkRoute checks that r can be added to the routing table of the host
+// and returns r with normalized gateway.
+func (h *Host) checkRoute(r Route) (Route, error) {
+	gw := normalizeIP(r.Gateway)
+	if gw == nil {
+		return Route{}, errors.New("no gateway")
+	}
+	if r.Dst != nil && ipFamily(r.Dst.IP) != ipFamily(gw) {
+		return Route{}, errors.New("gateway and destination families mismatch")
+	}
+	if !h.onLink(gw) || h.ownsUnlocked(gw, "") {
+		return Route{}, errors.New("gateway is not in a subnet of the host")
+	}
+	r.Gateway = gw
+	return r, nil
+}
+
+// addRoute adds route r checked by checkRoute.
+func (h *Host) addRoute(r Route) {
+	n := h.net
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	for i, old := range h.routes {
+		if sameNet(old.Dst, r.Dst) && ipFamily(old.Gateway) == ipFamily(r.Gateway) {
+			h.routes[i] = r
+			return
+		}
+	}
+	h.routes = append(h.routes, r)
+}
+
+// SetDefaultGateway sets the default route of the host for the address
+// family of gw.
+func (h *Host) SetDefaultGateway(gw net.IP) error {
+	return h.AddRoute(Route{Gateway: gw})
+}
+
+// Routes returns the routing table of the host.
+func (h *Host) Routes() []Route {
+	n := h.net
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return append([]Route(nil), h.routes...)
+}
+
+// sameNet reports whether a and b are the same network, nil being default.
+func sameNet(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.IP.Equal(b.IP) && a.Mask.String() == b.Mask.String()
+}
+
+// onLink reports whether ip is reachable without routers: it is an address
+// of the host, is link-local or is in a subnet of the host.
+func (h *Host) onLink(ip net.IP) bool {
+	if isLinkLocal(ip) {
+		return true
+	}
+	for _, a := range h.addrs {
+		if a.IP.Equal(ip) {
+			return true
+		}
+		if ones, bits := a.Mask.Size(); ones < bits && a.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextHopUnlocked returns address of the node to send packet to dst to:
+// dst itself if it is on-link or gateway of the longest matching route. It
+// returns false if there is no route.
+func (h *Host) nextHopUnlocked(dst net.IP) (net.IP, bool) {
+	if h.onLink(dst) {
+		return dst, true
+	}
+	var (
+		best  net.IP
+		bestN = -1
+	)
+	for _, r := range h.routes {
+		if ipFamily(r.Gateway) != ipFamily(dst) {
+			continue
+		}
+		ones := 0
+		if r.Dst != nil {
+			if !r.Dst.Contains(dst) {
+				continue
+			}
+			ones, _ = r.Dst.Mask.Size()
+		}
+		if ones > bestN {
+			best, bestN = r.Gateway, ones
+		}
+	}
+	return best, best != nil
+}
+
+// sourceAddrUnlocked is like sourceAddr but, if Net has routers, selects
+// address facing the next hop to dst.
+func (h *Host) sourceAddrUnlocked(dst *net.UDPAddr) hostAddr {
+	if h.net.routers > 0 {
+		if next, ok := h.nextHopUnlocked(dst.IP); ok {
+			dst = &net.UDPAddr{IP: next, Zone: dst.Zone}
+		}
+	}
+	return h.sourceAddr(dst)
+}
+
+// pathUnlocked returns hops of packet sent by h from src to dst with the
+// given TTL, and TTL the packet has at the end of the path. If the packet
+// does not reach dst, the drop reason at the last hop is returned. The error
+// is returned if h itself has no route to dst. Paths are at most maxTTL hops
+// long.
+func (n *Net) pathUnlocked(h *Host, src, dst net.IP, ttl int) ([]hop, int, DropReason, error) {
+	next, ok := h.nextHopUnlocked(dst)
+	if !ok {
+		return nil, 0, 0, errNetUnreach
+	}
+	hops := []hop{{from: src, to: next}}
+	for !next.Equal(dst) {
+		r := n.owners[ipKey(next)]
+		if r != nil && r.ownsUnlocked(dst, "") {
+			// Like Linux, hosts accept packets to any of their addresses
+			// on every interface.
+			break
+		}
+		if r == nil || !r.forwarding {
+			return hops, ttl, DropNoRoute, nil
+		}
+		if ttl <= 1 || len(hops) >= maxTTL {
+			return hops, ttl, DropTTL, nil
+		}
+		ttl--
+		if next, ok = r.nextHopUnlocked(dst); !ok {
+			return hops, ttl, DropNoRoute, nil
+		}
+		from := r.sourceAddr(&net.UDPAddr{IP: next}).IP
+		hops = append(hops, hop{from: from, to: next})
+	}
+	return hops, ttl, 0, nil
+}
+
+// transmitRoutedUnlocked applies link model of every hop to p sent at now and
+// returns transmissions that reach the end of the path and drop reasons of
+// the rest. Packets that reach the end of the path are dropped with the end
+// reason, if any. Hops after the first one drop packets that do not fit
+// into their MTU. Packet enters queue of every hop at the moment it arrives
+// there, see linkState.enqueue.
+func (n *Net) transmitRoutedUnlocked(now time.Time, hops []hop, end DropReason, p packet) ([]transmission, []DropReason) {
+	var (
+		flights = []transmission{{packet: p}}
+		drops   []DropReason
+	)
+	for i, h := range hops {
+		blocked := n.isBlockedUnlocked(h.from, h.to)
+		l, s := n.linkUnlocked(h.from, h.to)
+		var next []transmission
+		for _, f := range flights {
+			var reason DropReason
+			switch {
+			case blocked:
+				reason = DropPartition
+			case i > 0 && !l.Fragment && !l.fits(h.to, len(f.packet.buf)):
+				reason = DropMTU
+			default:
+				next, reason = l.transmitFragmented(next, n.rand, s, now, f.delay, h.to, f.packet)
+			}
+			if reason != 0 {
+				drops = append(drops, reason)
+				f.packet.release()
+			}
+		}
+		flights = next
+	}
+	if end != 0 {
+		for _, f := range flights {
+			drops = append(drops, end)
+			f.packet.release()
+		}
+		flights = nil
+	}
+	return flights, drops
+}
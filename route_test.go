@@ -0,0 +1,272 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func addHost(t *testing.T, nt *Net, router bool, addrs ...string) *Host {
+	t.Helper()
+	add := nt.AddHost
+	if router {
+		add = nt.AddRouter
+	}
+	h, err := add(addrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func addRoute(t *testing.T, h *Host, dst, gw string) {
+	t.Helper()
+	r := Route{Gateway: net.ParseIP(gw)}
+	if dst != "" {
+		_, ipNet, err := net.ParseCIDR(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Dst = ipNet
+	}
+	if err := h.AddRoute(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hostListen(t *testing.T, h *Host, address string) *PacketConn {
+	t.Helper()
+	c, err := h.ListenPacket("udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*PacketConn)
+}
+
+// routedNet returns network of hosts A and B connected by two paths:
+//
+//	A 10.0.1.2 -- 10.0.1.1 R1 10.0.2.1 -- 10.0.2.2 R2 10.0.3.1 -- 10.0.3.2 B
+//	A 10.0.4.2 -- 10.0.4.1 R3 10.0.5.1 ------------------------- 10.0.5.2 B
+//
+// A sends to B via R1 and R2, while B replies via R3. Every hop has 10ms
+// latency.
+func routedNet(t *testing.T, sim *Time) (nt *Net, a, b *PacketConn) {
+	nt = NewNet(sim)
+	nt.SetDefaultLink(Link{Latency: FixedLatency(10 * time.Millisecond)})
+
+	ha := addHost(t, nt, false, "10.0.1.2/24", "10.0.4.2/24")
+	r1 := addHost(t, nt, true, "10.0.1.1/24", "10.0.2.1/24")
+	addHost(t, nt, true, "10.0.2.2/24", "10.0.3.1/24")
+	r3 := addHost(t, nt, true, "10.0.4.1/24", "10.0.5.1/24")
+	hb := addHost(t, nt, false, "10.0.3.2/24", "10.0.5.2/24")
+
+	addRoute(t, ha, "10.0.3.0/24", "10.0.1.1")
+	addRoute(t, r1, "10.0.3.0/24", "10.0.2.2")
+	if err := hb.SetDefaultGateway(net.ParseIP("10.0.5.1")); err != nil {
+		t.Fatal(err)
+	}
+	addRoute(t, r3, "", "10.0.4.2")
+
+	return nt, hostListen(t, ha, "0.0.0.0:1"), hostListen(t, hb, "0.0.0.0:2")
+}
+
+func TestNet_Route(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt, a, b := routedNet(t, sim)
+	for _, c := range []*PacketConn{a, b} {
+		if err := c.SetControlMessage(FlagTTL, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Forward path has three hops and two routers.
+	dst := &net.UDPAddr{IP: net.IPv4(10, 0, 3, 2), Port: 2}
+	if _, err := a.WriteTo([]byte("hello"), dst); err != nil {
+		t.Fatal(err)
+	}
+	sim.Travel(29 * time.Millisecond)
+	if n := b.rx.len(); n != 0 {
+		t.Fatalf("got %d packets before arrival", n)
+	}
+	sim.Travel(time.Millisecond)
+	buf, oob := make([]byte, 1024), make([]byte, 64)
+	_, oobn, _, from, err := b.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "10.0.1.2:1" {
+		t.Errorf("bad source: %s", from)
+	}
+	cm, err := ParseControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.TTL != defaultTTL-2 {
+		t.Errorf("got TTL %d, expected %d", cm.TTL, defaultTTL-2)
+	}
+
+	// Return path is asymmetric: two hops via R3, with the source address
+	// facing the gateway.
+	if _, err = b.WriteTo([]byte("hello"), from); err != nil {
+		t.Fatal(err)
+	}
+	sim.Travel(20 * time.Millisecond)
+	_, oobn, _, from, err = a.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "10.0.5.2:2" {
+		t.Errorf("bad source: %s", from)
+	}
+	if cm, err = ParseControlMessage(oob[:oobn]); err != nil {
+		t.Fatal(err)
+	}
+	if cm.TTL != defaultTTL-1 {
+		t.Errorf("got TTL %d, expected %d", cm.TTL, defaultTTL-1)
+	}
+
+	if s := nt.LinkStats(net.IPv4(10, 0, 1, 2), net.IPv4(10, 0, 3, 2)); s.PacketsSent != 1 || s.PacketsReceived != 1 {
+		t.Errorf("unexpected path stats: %+v", s)
+	}
+}
+
+func TestNet_RouteUnreachable(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	_, a, b := routedNet(t, sim)
+
+	// A has no route to 10.0.6.0/24.
+	dst := &net.UDPAddr{IP: net.IPv4(10, 0, 6, 1), Port: 2}
+	if _, err := a.WriteTo([]byte("hello"), dst); !errors.Is(err, errNetUnreach) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := a.host.net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4(10, 0, 1, 2)}, dst); !errors.Is(err, errNetUnreach) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// R1 has no route to 10.0.6.0/24, while A sends there by default route.
+	if err := a.host.SetDefaultGateway(net.ParseIP("10.0.1.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.WriteTo([]byte("hello"), dst); err != nil {
+		t.Fatal(err)
+	}
+	if drops := a.Stats().Drops[DropNoRoute]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	// TTL expires at the second router.
+	cm := &ControlMessage{TTL: 2}
+	_, _, err := a.WriteMsgUDP([]byte("hello"), cm.Marshal(), &net.UDPAddr{IP: net.IPv4(10, 0, 3, 2), Port: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drops := a.Stats().Drops[DropTTL]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+	sim.Travel(time.Second)
+	if n := b.rx.len(); n != 0 {
+		t.Errorf("got %d packets, expected none", n)
+	}
+}
+
+func TestNet_RouteLoop(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	ha := addHost(t, nt, false, "10.0.1.2/24")
+	r1 := addHost(t, nt, true, "10.0.1.1/24", "10.0.2.1/24")
+	r2 := addHost(t, nt, true, "10.0.2.2/24")
+	addRoute(t, ha, "", "10.0.1.1")
+	addRoute(t, r1, "", "10.0.2.2")
+	addRoute(t, r2, "", "10.0.2.1")
+	a := hostListen(t, ha, "10.0.1.2:1")
+	dst := &net.UDPAddr{IP: net.IPv4(10, 0, 3, 2), Port: 2}
+
+	// TTL above 255 is rejected like IP_TTL of Linux.
+	cm := &ControlMessage{TTL: 1e7}
+	_, _, err := a.WriteMsgUDP([]byte("hello"), cm.Marshal(), dst)
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := err.(*net.OpError); !ok {
+		t.Errorf("got %T, expected *net.OpError", err)
+	}
+
+	cm.TTL = maxTTL
+	if _, _, err := a.WriteMsgUDP([]byte("hello"), cm.Marshal(), dst); err != nil {
+		t.Fatal(err)
+	}
+	if drops := a.Stats().Drops[DropTTL]; drops != 1 {
+		t.Errorf("got %d drops, expected 1", drops)
+	}
+
+	// Path is bounded even if TTL is not.
+	nt.mux.Lock()
+	hops, _, end, err := nt.pathUnlocked(ha, net.IPv4(10, 0, 1, 2), dst.IP, 1<<30)
+	nt.mux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != maxTTL || end != DropTTL {
+		t.Errorf("got %d hops and %s, expected %d and %s", len(hops), end, maxTTL, DropTTL)
+	}
+}
+
+func TestNet_RouteQueueOrder(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt := NewNet(sim)
+	ha := addHost(t, nt, false, "10.0.1.2/24")
+	hc := addHost(t, nt, false, "10.0.1.3/24")
+	addHost(t, nt, true, "10.0.1.1/24", "10.0.2.1/24")
+	hb := addHost(t, nt, false, "10.0.2.2/24")
+	addRoute(t, ha, "10.0.2.0/24", "10.0.1.1")
+	addRoute(t, hc, "10.0.2.0/24", "10.0.1.1")
+	nt.SetLink(net.IPv4(10, 0, 1, 2), net.IPv4(10, 0, 1, 1), Link{Latency: FixedLatency(200 * time.Millisecond)})
+	nt.SetLink(net.IPv4(10, 0, 2, 1), net.IPv4(10, 0, 2, 2), Link{Bandwidth: 1000})
+
+	a := hostListen(t, ha, "10.0.1.2:1")
+	c := hostListen(t, hc, "10.0.1.3:1")
+	b := hostListen(t, hb, "10.0.2.2:2")
+
+	// Packet of A is sent first but reaches the router at 200ms, so packet
+	// of C does not wait for it and takes 100ms to serialize.
+	for _, conn := range []*PacketConn{a, c} {
+		if _, err := conn.WriteTo(make([]byte, 100), b.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, expected := range []int{0, 1, 1, 2} {
+		if got := b.rx.len(); got != expected {
+			t.Errorf("step %d: got %d packets, expected %d", i, got, expected)
+		}
+		sim.Travel(100 * time.Millisecond)
+	}
+}
+
+func TestHost_AddRoute(t *testing.T) {
+	nt := NewNet(nil)
+	h := addHost(t, nt, false, "10.0.1.2/24")
+	for _, r := range []Route{
+		{},
+		{Gateway: net.ParseIP("10.0.2.1")},
+		{Gateway: net.ParseIP("10.0.1.2")},
+		{Gateway: net.ParseIP("10.0.1.1"), Dst: &net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(8, 128)}},
+	} {
+		if err := h.AddRoute(r); err == nil {
+			t.Errorf("%+v: expected error", r)
+		}
+	}
+
+	addRoute(t, h, "10.0.3.0/24", "10.0.1.1")
+	addRoute(t, h, "10.0.3.0/24", "10.0.1.3")
+	addRoute(t, h, "", "10.0.1.1")
+	routes := h.Routes()
+	if len(routes) != 2 || !routes[0].Gateway.Equal(net.IPv4(10, 0, 1, 3)) {
+		t.Errorf("unexpected routes: %v", routes)
+	}
+}
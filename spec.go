@@ -0,0 +1,902 @@
+package neo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is a declarative description of a simulated network, see ParseSpec
+// for the document format.
+//
+// Only JSON is supported, as the package has no YAML dependency. YAML
+// documents with the same structure can be converted to JSON, e.g. with yq.
+type Spec struct {
+	// Seed seeds the random source of links, zero keeps the default one.
+	Seed int64 `json:"seed"`
+	// DefaultLink is the link between hosts that have no other link.
+	DefaultLink *LinkSpec `json:"default_link"`
+	// PartitionReject makes writes across partitions fail, see
+	// Net.SetPartitionReject.
+	PartitionReject bool `json:"partition_reject"`
+
+	Subnets []SubnetSpec `json:"subnets"`
+	Hosts   []HostSpec   `json:"hosts"`
+	Links   []LinkEntry  `json:"links"`
+	NATs    []NATSpec    `json:"nats"`
+	Events  []EventSpec  `json:"events"`
+}
+
+// Duration is a duration like "1m30s", see time.ParseDuration. Empty
+// Duration is zero.
+type Duration string
+
+// parse returns non-negative duration d of field.
+func (d Duration) parse(field string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	v, err := time.ParseDuration(string(d))
+	if err != nil {
+		return 0, specErrorf(field, "bad duration %q", string(d))
+	}
+	if v < 0 {
+		return 0, specErrorf(field, "negative value")
+	}
+	return v, nil
+}
+
+// LinkSpec describes Link.
+type LinkSpec struct {
+	// Latency is the mean delay. If Jitter is set, delay is normally
+	// distributed with Jitter standard deviation, otherwise it is fixed.
+	Latency Duration `json:"latency"`
+	Jitter  Duration `json:"jitter"`
+
+	Loss          float64  `json:"loss"`
+	Duplicate     float64  `json:"duplicate"`
+	Corrupt       float64  `json:"corrupt"`
+	Reorder       float64  `json:"reorder"`
+	ReorderWindow Duration `json:"reorder_window"`
+
+	// Bandwidth is in payload bytes per second.
+	Bandwidth int `json:"bandwidth"`
+	// Queue is the limit of TailDrop queue, zero means unlimited queue. It
+	// requires Bandwidth.
+	Queue int `json:"queue"`
+
+	MTU      int  `json:"mtu"`
+	Fragment bool `json:"fragment"`
+}
+
+// SubnetSpec describes a subnet. Addresses of hosts without prefix length
+// get the one of their subnet.
+type SubnetSpec struct {
+	CIDR string `json:"cidr"`
+	// Link is the link between hosts of the subnet, if any.
+	Link *LinkSpec `json:"link"`
+}
+
+// HostSpec describes a host.
+type HostSpec struct {
+	// Name is the unique name of the host, which can be used instead of its
+	// addresses in links and events.
+	Name  string   `json:"name"`
+	Addrs []string `json:"addrs"`
+	// Router makes the host forward packets, see Net.AddRouter.
+	Router bool        `json:"router"`
+	Routes []RouteSpec `json:"routes"`
+
+	Firewall *FirewallSpec `json:"firewall"`
+}
+
+// RouteSpec describes Route. Empty Dst means default route.
+type RouteSpec struct {
+	Dst     string `json:"dst"`
+	Gateway string `json:"gateway"`
+}
+
+// FirewallSpec describes Firewall. Policy is "accept", the default, or
+// "deny".
+type FirewallSpec struct {
+	Policy      string     `json:"policy"`
+	Rules       []RuleSpec `json:"rules"`
+	FlowTimeout Duration   `json:"flow_timeout"`
+}
+
+// RuleSpec describes Rule.
+type RuleSpec struct {
+	// Action is "accept", the default, or "deny".
+	Action string `json:"action"`
+	// Direction is "inbound", "outbound" or empty for any.
+	Direction string `json:"direction"`
+
+	// Src and Dst are networks in CIDR notation.
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// SrcPorts and DstPorts are ports like "53" or ranges like "1000-2000".
+	SrcPorts string `json:"src_ports"`
+	DstPorts string `json:"dst_ports"`
+
+	Established bool `json:"established"`
+}
+
+// LinkEntry is a link between hosts, referenced by name or address. Links
+// of host name apply to all addresses of the host.
+type LinkEntry struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Bidirectional sets the link in the opposite direction too.
+	Bidirectional bool `json:"bidirectional"`
+
+	LinkSpec
+}
+
+// NATSpec describes NAT. Forward maps public ports to private addresses
+// like "10.1.0.1:20000".
+type NATSpec struct {
+	Inside     []string       `json:"inside"`
+	Outside    string         `json:"outside"`
+	Forward    map[int]string `json:"forward"`
+	Restricted bool           `json:"restricted"`
+	Timeout    Duration       `json:"timeout"`
+}
+
+// EventSpec is a change of network scheduled At the given time after Apply.
+//
+// Action is one of:
+//
+//	"partition"          Net.Partition of Groups
+//	"partition_one_way"  Net.PartitionOneWay From to To
+//	"heal"               Net.Heal
+//	"link"               Net.SetLink of Link
+type EventSpec struct {
+	At     Duration `json:"at"`
+	Action string   `json:"action"`
+
+	Groups [][]string `json:"groups"`
+	From   []string   `json:"from"`
+	To     []string   `json:"to"`
+	Link   *LinkEntry `json:"link"`
+}
+
+// SpecError is an error of Spec field, like "hosts[1].addrs[0]".
+type SpecError struct {
+	Field string
+	Err   error
+}
+
+func (e *SpecError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *SpecError) Unwrap() error { return e.Err }
+
+// specErrorf returns SpecError of field.
+func specErrorf(field, format string, args ...interface{}) error {
+	return &SpecError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// ParseSpec parses JSON document of Spec. Unknown fields are rejected.
+//
+// Example:
+//
+//	{
+//	  "default_link": {"latency": "10ms"},
+//	  "subnets": [{"cidr": "10.0.0.0/24", "link": {"latency": "1ms"}}],
+//	  "hosts": [
+//	    {"name": "a", "addrs": ["10.0.0.1"]},
+//	    {"name": "b", "addrs": ["10.0.0.2"], "firewall": {
+//	      "policy": "deny",
+//	      "rules": [{"direction": "inbound", "dst_ports": "53"}]
+//	    }},
+//	    {"name": "c", "addrs": ["192.168.0.1/24"]}
+//	  ],
+//	  "links": [
+//	    {"from": "a", "to": "c", "bidirectional": true, "latency": "50ms", "loss": 0.01}
+//	  ],
+//	  "nats": [{"inside": ["192.168.0.0/24"], "outside": "83.30.100.1"}],
+//	  "events": [
+//	    {"at": "1s", "action": "partition", "groups": [["a"], ["b"]]},
+//	    {"at": "2s", "action": "heal"}
+//	  ]
+//	}
+func ParseSpec(data []byte) (*Spec, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	s := new(Spec)
+	if err := d.Decode(s); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, specErrorf(jsonField(typeErr.Field), "cannot use %s as %s", typeErr.Value, typeErr.Type)
+		}
+		// Decoder does not report path of unknown field, so it is found
+		// in the generic document.
+		var doc interface{}
+		if json.Unmarshal(data, &doc) == nil {
+			if field, ok := unknownField(reflect.TypeOf(s), doc, ""); ok {
+				return nil, specErrorf(field, "unknown field")
+			}
+		}
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("data after document")
+	}
+	return s, nil
+}
+
+// jsonField converts field path of encoding/json, like "hosts.0.addrs", to
+// the one of SpecError, like "hosts[0].addrs".
+func jsonField(path string) string {
+	var b strings.Builder
+	for i, name := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(name); err == nil {
+			b.WriteString("[" + name + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// unknownField returns path of the first field of JSON value v, in order of
+// keys, that type t has no field for, like "hosts[0].addrz". Like
+// encoding/json, struct fields match keys case-insensitively.
+func unknownField(t reflect.Type, v interface{}, path string) (string, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			elem := t
+			switch t.Kind() {
+			case reflect.Struct:
+				f, ok := jsonStructField(t, k)
+				if !ok {
+					return p, true
+				}
+				elem = f.Type
+			case reflect.Map:
+				elem = t.Elem()
+			default:
+				return "", false
+			}
+			if p, ok := unknownField(elem, v[k], p); ok {
+				return p, true
+			}
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return "", false
+		}
+		for i, e := range v {
+			if p, ok := unknownField(t.Elem(), e, fmt.Sprintf("%s[%d]", path, i)); ok {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// jsonStructField returns field of struct t for JSON key, preferring exact
+// match of the name.
+func jsonStructField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var (
+		fold  reflect.StructField
+		found bool
+	)
+	for _, f := range reflect.VisibleFields(t) {
+		if f.Anonymous || !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		if name == key {
+			return f, true
+		}
+		if !found && strings.EqualFold(name, key) {
+			fold, found = f, true
+		}
+	}
+	return fold, found
+}
+
+// Load parses Spec from r and applies it to a new Net bound to t, returning
+// the network and its hosts by name.
+func Load(r io.Reader, t *Time) (*Net, map[string]*Host, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := ParseSpec(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := NewNet(t)
+	hosts, err := s.Apply(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n, hosts, nil
+}
+
+// Apply adds hosts, links, NATs and events of s to n and returns hosts by
+// name. Events are scheduled relative to the current time of n.
+//
+// Errors are *SpecError of the offending field. The whole spec is checked
+// before n is changed, so an invalid spec leaves n as is.
+func (s *Spec) Apply(n *Net) (map[string]*Host, error) {
+	a := &applier{net: n, hosts: map[string]*Host{}, addrs: map[netip.Addr]bool{}}
+	if err := a.check(s); err != nil {
+		return nil, err
+	}
+	if err := n.addHosts(a.added...); err != nil {
+		return nil, &SpecError{Field: "hosts", Err: err}
+	}
+	for i, t := range a.nats {
+		if err := n.addNAT(t); err != nil {
+			return nil, &SpecError{Field: fmt.Sprintf("nats[%d]", i), Err: err}
+		}
+	}
+	if s.Seed != 0 {
+		n.Seed(s.Seed)
+	}
+	n.SetPartitionReject(s.PartitionReject)
+	for _, f := range a.changes {
+		f()
+	}
+	return a.hosts, nil
+}
+
+// applier is the state of Spec.Apply.
+type applier struct {
+	net *Net
+	// hosts are named hosts, added are all hosts in order and addrs are
+	// their addresses. Hosts are added to net once the spec is checked.
+	hosts map[string]*Host
+	added []*Host
+	addrs map[netip.Addr]bool
+	// nats are NATs to add.
+	nats []*nat
+	// changes are the rest of changes to net, made after hosts and NATs
+	// are added.
+	changes []func()
+}
+
+// check checks s, collecting hosts, NATs and changes of Apply.
+func (a *applier) check(s *Spec) error {
+	if s.DefaultLink != nil {
+		l, err := s.DefaultLink.link("default_link")
+		if err != nil {
+			return err
+		}
+		a.changes = append(a.changes, func() { a.net.SetDefaultLink(l) })
+	}
+
+	subnets := make([]*net.IPNet, len(s.Subnets))
+	for i, sub := range s.Subnets {
+		_, ipNet, err := net.ParseCIDR(sub.CIDR)
+		if err != nil {
+			return specErrorf(fmt.Sprintf("subnets[%d].cidr", i), "bad network %q", sub.CIDR)
+		}
+		subnets[i] = ipNet
+	}
+	for i, h := range s.Hosts {
+		if err := a.addHost(fmt.Sprintf("hosts[%d]", i), h, subnets); err != nil {
+			return err
+		}
+	}
+	for i, h := range s.Hosts {
+		if err := a.configureHost(fmt.Sprintf("hosts[%d]", i), h); err != nil {
+			return err
+		}
+	}
+	if err := a.subnetLinks(s.Subnets, subnets); err != nil {
+		return err
+	}
+	if err := a.links(s.Links); err != nil {
+		return err
+	}
+	if err := a.checkNATs(s.NATs); err != nil {
+		return err
+	}
+	return a.events(s.Events)
+}
+
+// subnetLinks sets links of subs between hosts in their networks.
+func (a *applier) subnetLinks(subs []SubnetSpec, subnets []*net.IPNet) error {
+	for i, sub := range subs {
+		if sub.Link == nil {
+			continue
+		}
+		l, err := sub.Link.link(fmt.Sprintf("subnets[%d].link", i))
+		if err != nil {
+			return err
+		}
+		var ips []net.IP
+		for _, h := range a.added {
+			for _, addr := range h.Addrs() {
+				if subnets[i].Contains(addr.IP) {
+					ips = append(ips, addr.IP)
+				}
+			}
+		}
+		a.changes = append(a.changes, func() {
+			for _, from := range ips {
+				for _, to := range ips {
+					if !from.Equal(to) {
+						a.net.SetLink(from, to, l)
+					}
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// links sets links of entries.
+func (a *applier) links(entries []LinkEntry) error {
+	for i, e := range entries {
+		set, err := a.link(fmt.Sprintf("links[%d]", i), e)
+		if err != nil {
+			return err
+		}
+		a.changes = append(a.changes, set)
+	}
+	return nil
+}
+
+// checkNATs checks NATs of specs, which must not share outside address with
+// each other or with NATs of net.
+func (a *applier) checkNATs(specs []NATSpec) error {
+	for i, spec := range specs {
+		field := fmt.Sprintf("nats[%d]", i)
+		cfg, err := spec.nat(field)
+		if err != nil {
+			return err
+		}
+		t, err := newNAT(cfg)
+		if err != nil {
+			return &SpecError{Field: field, Err: err}
+		}
+		inUse := a.net.natInUse(t.Outside)
+		for _, other := range a.nats {
+			inUse = inUse || other.Outside.Equal(t.Outside)
+		}
+		if inUse {
+			return &SpecError{Field: field + ".outside", Err: errNATInUse}
+		}
+		a.nats = append(a.nats, t)
+	}
+	return nil
+}
+
+// events schedules events relative to the current time.
+func (a *applier) events(events []EventSpec) error {
+	now := a.net.now()
+	for i, e := range events {
+		field := fmt.Sprintf("events[%d]", i)
+		d, err := e.At.parse(field + ".at")
+		if err != nil {
+			return err
+		}
+		f, err := a.event(field, e)
+		if err != nil {
+			return err
+		}
+		at := now.Add(d)
+		a.changes = append(a.changes, func() { a.net.At(at, f) })
+	}
+	return nil
+}
+
+// addHost creates host h described by field, which Apply adds to net.
+func (a *applier) addHost(field string, h HostSpec, subnets []*net.IPNet) error {
+	if h.Name == "" {
+		return specErrorf(field+".name", "no name")
+	}
+	if a.hosts[h.Name] != nil {
+		return specErrorf(field+".name", "duplicate name %q", h.Name)
+	}
+	if len(h.Addrs) == 0 {
+		return specErrorf(field+".addrs", "no addresses")
+	}
+	addrs := make([]string, len(h.Addrs))
+	for i, s := range h.Addrs {
+		addrs[i] = s
+		if strings.IndexByte(s, '/') >= 0 {
+			continue
+		}
+		ip, _ := splitZone(s)
+		for _, sub := range subnets {
+			if sub.Contains(net.ParseIP(ip)) {
+				ones, _ := sub.Mask.Size()
+				addrs[i] = fmt.Sprintf("%s/%d", s, ones)
+				break
+			}
+		}
+		if _, err := parseHostAddr(addrs[i]); err != nil {
+			return specErrorf(fmt.Sprintf("%s.addrs[%d]", field, i), "bad address %q", s)
+		}
+	}
+	host, err := a.net.newHost(h.Router, addrs)
+	if err != nil {
+		return &SpecError{Field: field + ".addrs", Err: err}
+	}
+	for _, addr := range host.addrs {
+		if a.addrs[addr.key()] {
+			return &SpecError{Field: field + ".addrs", Err: errAddrInUse}
+		}
+	}
+	for _, addr := range host.addrs {
+		a.addrs[addr.key()] = true
+	}
+	a.hosts[h.Name] = host
+	a.added = append(a.added, host)
+	return nil
+}
+
+// configureHost checks routes and firewall of host h described by field.
+func (a *applier) configureHost(field string, h HostSpec) error {
+	host := a.hosts[h.Name]
+	for i, r := range h.Routes {
+		f := fmt.Sprintf("%s.routes[%d]", field, i)
+		route := Route{Gateway: net.ParseIP(r.Gateway)}
+		if route.Gateway == nil {
+			return specErrorf(f+".gateway", "bad address %q", r.Gateway)
+		}
+		if r.Dst != "" {
+			_, ipNet, err := net.ParseCIDR(r.Dst)
+			if err != nil {
+				return specErrorf(f+".dst", "bad network %q", r.Dst)
+			}
+			route.Dst = ipNet
+		}
+		route, err := host.checkRoute(route)
+		if err != nil {
+			return &SpecError{Field: f, Err: err}
+		}
+		a.changes = append(a.changes, func() { host.addRoute(route) })
+	}
+	if h.Firewall != nil {
+		fw, err := h.Firewall.firewall(field + ".firewall")
+		if err != nil {
+			return err
+		}
+		a.changes = append(a.changes, func() { host.SetFirewall(fw) })
+	}
+	return nil
+}
+
+// resolve returns addresses of host with the given name or the address
+// itself.
+func (a *applier) resolve(field, ref string) ([]net.IP, error) {
+	if h := a.hosts[ref]; h != nil {
+		var ips []net.IP
+		for _, addr := range h.Addrs() {
+			ips = append(ips, addr.IP)
+		}
+		return ips, nil
+	}
+	if ip := net.ParseIP(ref); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return nil, specErrorf(field, "unknown host %q", ref)
+}
+
+// resolveAll is like resolve for every reference of refs.
+func (a *applier) resolveAll(field string, refs []string) ([]net.IP, error) {
+	var ips []net.IP
+	for i, ref := range refs {
+		r, err := a.resolve(fmt.Sprintf("%s[%d]", field, i), ref)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, r...)
+	}
+	return ips, nil
+}
+
+// link returns function setting link e described by field.
+func (a *applier) link(field string, e LinkEntry) (func(), error) {
+	from, err := a.resolve(field+".from", e.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := a.resolve(field+".to", e.To)
+	if err != nil {
+		return nil, err
+	}
+	l, err := e.link(field)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		for _, x := range from {
+			for _, y := range to {
+				a.net.SetLink(x, y, l)
+				if e.Bidirectional {
+					a.net.SetLink(y, x, l)
+				}
+			}
+		}
+	}, nil
+}
+
+// event returns function running event e described by field.
+func (a *applier) event(field string, e EventSpec) (func(), error) {
+	n := a.net
+	switch e.Action {
+	case "partition":
+		if len(e.Groups) < 2 {
+			return nil, specErrorf(field+".groups", "less than two groups")
+		}
+		groups := make([][]net.IP, len(e.Groups))
+		for i, g := range e.Groups {
+			ips, err := a.resolveAll(fmt.Sprintf("%s.groups[%d]", field, i), g)
+			if err != nil {
+				return nil, err
+			}
+			groups[i] = ips
+		}
+		return func() { n.Partition(groups...) }, nil
+	case "partition_one_way":
+		from, err := a.resolveAll(field+".from", e.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := a.resolveAll(field+".to", e.To)
+		if err != nil {
+			return nil, err
+		}
+		if len(from) == 0 || len(to) == 0 {
+			return nil, specErrorf(field, "no hosts")
+		}
+		return func() { n.PartitionOneWay(from, to) }, nil
+	case "heal":
+		return n.Heal, nil
+	case "link":
+		if e.Link == nil {
+			return nil, specErrorf(field+".link", "no link")
+		}
+		return a.link(field+".link", *e.Link)
+	default:
+		return nil, specErrorf(field+".action", "unknown action %q", e.Action)
+	}
+}
+
+// link returns Link of s described by field.
+func (s LinkSpec) link(field string) (Link, error) {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{
+		{"loss", s.Loss},
+		{"duplicate", s.Duplicate},
+		{"corrupt", s.Corrupt},
+		{"reorder", s.Reorder},
+	} {
+		if p.value < 0 || p.value > 1 {
+			return Link{}, specErrorf(field+"."+p.name, "probability %v is out of [0, 1]", p.value)
+		}
+	}
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"bandwidth", s.Bandwidth},
+		{"queue", s.Queue},
+		{"mtu", s.MTU},
+	} {
+		if v.value < 0 {
+			return Link{}, specErrorf(field+"."+v.name, "negative value")
+		}
+	}
+	if s.Queue > 0 && s.Bandwidth == 0 {
+		return Link{}, specErrorf(field+".queue", "queue without bandwidth")
+	}
+	latency, err := s.Latency.parse(field + ".latency")
+	if err != nil {
+		return Link{}, err
+	}
+	jitter, err := s.Jitter.parse(field + ".jitter")
+	if err != nil {
+		return Link{}, err
+	}
+	window, err := s.ReorderWindow.parse(field + ".reorder_window")
+	if err != nil {
+		return Link{}, err
+	}
+	l := Link{
+		Loss:          s.Loss,
+		Duplicate:     s.Duplicate,
+		Corrupt:       s.Corrupt,
+		Reorder:       s.Reorder,
+		ReorderWindow: window,
+		Bandwidth:     s.Bandwidth,
+		MTU:           s.MTU,
+		Fragment:      s.Fragment,
+	}
+	switch {
+	case jitter > 0:
+		l.Latency = NormalLatency{Mean: latency, StdDev: jitter}
+	case latency > 0:
+		l.Latency = FixedLatency(latency)
+	}
+	if s.Queue > 0 {
+		l.Queue = TailDrop{Limit: s.Queue}
+	}
+	return l, nil
+}
+
+// firewall returns Firewall of s described by field.
+func (s FirewallSpec) firewall(field string) (*Firewall, error) {
+	policy, err := parseAction(field+".policy", s.Policy)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := s.FlowTimeout.parse(field + ".flow_timeout")
+	if err != nil {
+		return nil, err
+	}
+	f := &Firewall{Policy: policy, FlowTimeout: timeout}
+	for i, r := range s.Rules {
+		rule, err := r.rule(fmt.Sprintf("%s.rules[%d]", field, i))
+		if err != nil {
+			return nil, err
+		}
+		f.Rules = append(f.Rules, rule)
+	}
+	return f, nil
+}
+
+// rule returns Rule of s described by field.
+func (s RuleSpec) rule(field string) (Rule, error) {
+	var (
+		r   Rule
+		err error
+	)
+	if r.Action, err = parseAction(field+".action", s.Action); err != nil {
+		return Rule{}, err
+	}
+	switch s.Direction {
+	case "":
+	case "inbound":
+		r.Direction = Inbound
+	case "outbound":
+		r.Direction = Outbound
+	default:
+		return Rule{}, specErrorf(field+".direction", "unknown direction %q", s.Direction)
+	}
+	for _, n := range []struct {
+		name  string
+		value string
+		ipNet **net.IPNet
+	}{
+		{"src", s.Src, &r.Src},
+		{"dst", s.Dst, &r.Dst},
+	} {
+		if n.value == "" {
+			continue
+		}
+		if _, *n.ipNet, err = net.ParseCIDR(n.value); err != nil {
+			return Rule{}, specErrorf(field+"."+n.name, "bad network %q", n.value)
+		}
+	}
+	if r.SrcPorts, err = parsePortRange(field+".src_ports", s.SrcPorts); err != nil {
+		return Rule{}, err
+	}
+	if r.DstPorts, err = parsePortRange(field+".dst_ports", s.DstPorts); err != nil {
+		return Rule{}, err
+	}
+	r.Established = s.Established
+	return r, nil
+}
+
+// nat returns NAT of s described by field.
+func (s NATSpec) nat(field string) (*NAT, error) {
+	timeout, err := s.Timeout.parse(field + ".timeout")
+	if err != nil {
+		return nil, err
+	}
+	cfg := &NAT{
+		Outside:    net.ParseIP(s.Outside),
+		Restricted: s.Restricted,
+		Timeout:    timeout,
+	}
+	if cfg.Outside == nil {
+		return nil, specErrorf(field+".outside", "bad address %q", s.Outside)
+	}
+	for i, inside := range s.Inside {
+		_, ipNet, err := net.ParseCIDR(inside)
+		if err != nil {
+			return nil, specErrorf(fmt.Sprintf("%s.inside[%d]", field, i), "bad network %q", inside)
+		}
+		cfg.Inside = append(cfg.Inside, ipNet)
+	}
+	// Ports are sorted, so that the error is about the same port every time.
+	ports := make([]int, 0, len(s.Forward))
+	for port := range s.Forward {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	if len(ports) > 0 {
+		cfg.Forward = map[int]*net.UDPAddr{}
+	}
+	for _, port := range ports {
+		to := s.Forward[port]
+		f := fmt.Sprintf("%s.forward.%d", field, port)
+		host, p, err := net.SplitHostPort(to)
+		if err != nil {
+			return nil, &SpecError{Field: f, Err: err}
+		}
+		ip := net.ParseIP(host)
+		portN, err := strconv.Atoi(p)
+		if ip == nil || err != nil || portN <= 0 || portN > 0xffff {
+			return nil, specErrorf(f, "bad address %q", to)
+		}
+		cfg.Forward[port] = &net.UDPAddr{IP: ip, Port: portN}
+	}
+	return cfg, nil
+}
+
+// parseAction parses firewall action s, empty meaning Accept.
+func parseAction(field, s string) (Action, error) {
+	switch s {
+	case "", "accept":
+		return Accept, nil
+	case "deny":
+		return Deny, nil
+	default:
+		return 0, specErrorf(field, "unknown action %q", s)
+	}
+}
+
+// parsePortRange parses port like "53" or range like "1000-2000", empty
+// meaning any port.
+func parsePortRange(field, s string) (PortRange, error) {
+	if s == "" {
+		return PortRange{}, nil
+	}
+	min, max := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		min, max = s[:i], s[i+1:]
+	}
+	var (
+		r    PortRange
+		err1 error
+		err2 error
+	)
+	r.Min, err1 = strconv.Atoi(min)
+	r.Max, err2 = strconv.Atoi(max)
+	if err1 != nil || err2 != nil || r.Min <= 0 || r.Max > 0xffff || r.Min > r.Max {
+		return PortRange{}, specErrorf(field, "bad port range %q", s)
+	}
+	return r, nil
+}
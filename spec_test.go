@@ -0,0 +1,185 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSpec = `{
+  "default_link": {"latency": "10ms"},
+  "subnets": [{"cidr": "10.0.0.0/24", "link": {"latency": "1ms", "jitter": "100us"}}],
+  "hosts": [
+    {"name": "a", "addrs": ["10.0.0.1"]},
+    {"name": "b", "addrs": ["10.0.0.2"], "firewall": {
+      "policy": "deny",
+      "rules": [{"direction": "inbound", "src": "10.0.0.0/24", "dst_ports": "53-54"}]
+    }},
+    {"name": "c", "addrs": ["192.168.0.1/24"]},
+    {"name": "d", "addrs": ["91.10.100.2"]}
+  ],
+  "links": [
+    {"from": "a", "to": "d", "bidirectional": true, "latency": "50ms", "loss": 0.5, "bandwidth": 1000, "queue": 10}
+  ],
+  "nats": [{"inside": ["192.168.0.0/24"], "outside": "83.30.100.1", "forward": {"8080": "192.168.0.1:80"}}],
+  "events": [
+    {"at": "1s", "action": "partition", "groups": [["a"], ["b", "10.0.0.3"]]},
+    {"at": "2s", "action": "heal"},
+    {"at": "3s", "action": "link", "link": {"from": "a", "to": "b", "loss": 1}}
+  ]
+}`
+
+func TestLoad(t *testing.T) {
+	now := time.Date(2049, 5, 6, 23, 55, 11, 1034, time.UTC)
+	sim := NewTime(now)
+	nt, hosts, err := Load(strings.NewReader(testSpec), sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 4 {
+		t.Fatalf("got %d hosts", len(hosts))
+	}
+	if addr := hosts["a"].Addrs()[0]; addr.String() != "10.0.0.1/24" {
+		t.Errorf("got address %s", addr.String())
+	}
+	if addr := hosts["d"].Addrs()[0]; addr.String() != "91.10.100.2/32" {
+		t.Errorf("got address %s", addr.String())
+	}
+
+	a, b, d := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(91, 10, 100, 2)
+	if l, _ := nt.linkUnlocked(a, b); l.Latency != (NormalLatency{Mean: time.Millisecond, StdDev: 100 * time.Microsecond}) {
+		t.Errorf("unexpected subnet link: %+v", l)
+	}
+	if l, _ := nt.linkUnlocked(b, d); l.Latency != FixedLatency(10*time.Millisecond) {
+		t.Errorf("unexpected default link: %+v", l)
+	}
+	if l, _ := nt.linkUnlocked(d, a); l.Loss != 0.5 || l.Queue != (TailDrop{Limit: 10}) {
+		t.Errorf("unexpected link: %+v", l)
+	}
+
+	// Firewall of b accepts only DNS from its subnet.
+	c := hostListen(t, hosts["a"], "10.0.0.1:5000")
+	for port, want := range map[int]int{53: 1, 80: 0} {
+		s := hostListen(t, hosts["b"], (&net.UDPAddr{IP: b, Port: port}).String())
+		if _, err := c.WriteTo([]byte("hello"), s.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		sim.Travel(time.Millisecond * 2)
+		if n := s.rx.len(); n != want {
+			t.Errorf("port %d: got %d packets, expected %d", port, n, want)
+		}
+	}
+
+	// NAT forwards public port to c.
+	srv := hostListen(t, hosts["c"], "192.168.0.1:80")
+	client := hostListen(t, hosts["d"], "91.10.100.2:5000")
+	if _, err := client.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.IPv4(83, 30, 100, 1), Port: 8080}); err != nil {
+		t.Fatal(err)
+	}
+	sim.Travel(10 * time.Millisecond)
+	if n := srv.rx.len(); n != 1 {
+		t.Errorf("got %d packets, expected 1", n)
+	}
+
+	sim.Set(now.Add(time.Second))
+	if !nt.isBlockedUnlocked(a, b) {
+		t.Error("expected partition")
+	}
+	sim.Set(now.Add(2 * time.Second))
+	if nt.isBlockedUnlocked(a, b) {
+		t.Error("expected heal")
+	}
+	sim.Set(now.Add(3 * time.Second))
+	if l, _ := nt.linkUnlocked(a, b); l.Loss != 1 {
+		t.Errorf("unexpected link after event: %+v", l)
+	}
+}
+
+func TestSpec_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		Document string
+		Field    string
+	}{
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.1"]}, {"name": "a", "addrs": ["10.0.0.2"]}]}`, "hosts[1].name"},
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.1", "bad"]}]}`, "hosts[0].addrs[1]"},
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.1"]}, {"name": "b", "addrs": ["10.0.0.1"]}]}`, "hosts[1].addrs"},
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.1/24"], "routes": [{"gateway": "10.1.0.1"}]}]}`, "hosts[0].routes[0]"},
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.1"], "firewall": {"rules": [{}, {"dst_ports": "2-1"}]}}]}`, "hosts[0].firewall.rules[1].dst_ports"},
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.1"], "firewall": {"policy": "drop"}}]}`, "hosts[0].firewall.policy"},
+		{`{"subnets": [{"cidr": "10.0.0.0"}]}`, "subnets[0].cidr"},
+		{`{"links": [{"from": "10.0.0.1", "to": "10.0.0.2", "loss": 2}]}`, "links[0].loss"},
+		{`{"links": [{"from": "a", "to": "10.0.0.2"}]}`, "links[0].from"},
+		{`{"nats": [{"inside": ["10.0.0.0/8"], "outside": "10.0.0.1"}]}`, "nats[0]"},
+		{`{"nats": [{"inside": ["10.0.0.0/8"], "outside": "1.1.1.1", "forward": {"80": "10.0.0.1"}}]}`, "nats[0].forward.80"},
+		{`{"events": [{"at": "1s", "action": "partition", "groups": [["10.0.0.1"], ["b"]]}]}`, "events[0].groups[1][0]"},
+		{`{"events": [{"at": "1s", "action": "explode"}]}`, "events[0].action"},
+		{`{"hosts": [{"name": "a", "addrs": "10.0.0.1"}]}`, "hosts[0].addrs"},
+		{`{"default_link": {"loss": "high"}}`, "default_link.loss"},
+		{`{"default_link": {"latency": 10}}`, "default_link.latency"},
+		{`{"links": [{"from": "10.0.0.1", "to": "10.0.0.2", "latency": "soon"}]}`, "links[0].latency"},
+		{`{"events": [{"at": "-1s", "action": "heal"}]}`, "events[0].at"},
+		{`{"hosts": [{"name": "a", "addrz": ["10.0.0.1"]}]}`, "hosts[0].addrz"},
+		{`{"links": [{"from": "a", "to": "b", "latency": "1ms", "jiter": "1ms"}]}`, "links[0].jiter"},
+		{`{"nats": [{"forward": {"80": "10.0.0.1:80"}, "outsid": "1.1.1.1"}]}`, "nats[0].outsid"},
+		{`{"nats": [{"inside": ["10.0.0.0/8"], "outside": "1.1.1.1", "forward": {"81": "bad", "80": "10.0.0.1"}}]}`, "nats[0].forward.80"},
+		{`{"default_link": {"queue": 5}}`, "default_link.queue"},
+		{`{"nats": [{"inside": ["10.0.0.0/8"], "outside": "1.1.1.1"}, {"inside": ["10.1.0.0/16"], "outside": "1.1.1.1"}]}`, "nats[1].outside"},
+		{`{"default_link": {"loss": 1}, "hosts": [{"name": "a", "addrs": ["10.0.0.1"]}], "events": [{"at": "1s", "action": "partition", "groups": [["a"]]}]}`, "events[0].groups"},
+	} {
+		t.Run(tt.Field, func(t *testing.T) {
+			nt := NewNet(nil)
+			s, err := ParseSpec([]byte(tt.Document))
+			if err == nil {
+				_, err = s.Apply(nt)
+			}
+			var specErr *SpecError
+			if !errors.As(err, &specErr) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if specErr.Field != tt.Field {
+				t.Errorf("got field %q, expected %q: %v", specErr.Field, tt.Field, err)
+			}
+			// Invalid spec leaves the network as is.
+			if len(nt.hosts) != 0 || len(nt.nats) != 0 || nt.defaultLink != (Link{}) {
+				t.Errorf("network changed: %d hosts, %d NATs, default link %+v", len(nt.hosts), len(nt.nats), nt.defaultLink)
+			}
+		})
+	}
+
+	// Hosts and NATs of spec must not be in use in the network.
+	for _, tt := range []struct {
+		Document string
+		Field    string
+	}{
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.2"]}, {"name": "b", "addrs": ["10.0.0.1"]}]}`, "hosts"},
+		{`{"hosts": [{"name": "a", "addrs": ["10.0.0.2"]}], "nats": [{"inside": ["10.0.0.0/8"], "outside": "1.1.1.1"}]}`, "nats[0].outside"},
+	} {
+		t.Run(tt.Field, func(t *testing.T) {
+			nt := NewNet(nil)
+			if _, err := nt.AddHost("10.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := nt.AddNAT(&NAT{Inside: []*net.IPNet{{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)}}, Outside: net.IPv4(1, 1, 1, 1)}); err != nil {
+				t.Fatal(err)
+			}
+			s, err := ParseSpec([]byte(tt.Document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.Apply(nt)
+			var specErr *SpecError
+			if !errors.As(err, &specErr) || specErr.Field != tt.Field {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(nt.hosts) != 1 || len(nt.nats) != 1 {
+				t.Errorf("network changed: %d hosts, %d NATs", len(nt.hosts), len(nt.nats))
+			}
+		})
+	}
+
+	if _, err := ParseSpec([]byte(`{"hosts": [], "routers": []}`)); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
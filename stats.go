@@ -0,0 +1,200 @@
+package neo
+
+import (
+	"net"
+	"sync"
+)
+
+// DropReason describes why a packet was dropped.
+type DropReason int
+
+// Drop reasons.
+const (
+	// DropLoss is a drop by the link loss model.
+	DropLoss DropReason = iota + 1
+	// DropQueue is a drop by the link bottleneck queue discipline.
+	DropQueue
+	// DropPartition is a drop of a packet crossing a partition.
+	DropPartition
+	// DropReadBuffer is a drop on receive buffer overflow.
+	DropReadBuffer
+	// DropWriteBuffer is a drop on send buffer overflow.
+	DropWriteBuffer
+	// DropNoListener is a drop of a packet sent to an address nobody
+	// listens on.
+	DropNoListener
+	// DropIntercept is a drop by interceptor.
+	DropIntercept
+	// DropFirewall is a drop by host firewall.
+	DropFirewall
+	// DropNoRoute is a drop by router that has no route to destination.
+	DropNoRoute
+	// DropTTL is a drop by router of a packet whose TTL expired.
+	DropTTL
+	// DropMTU is a drop by router of a packet that does not fit into MTU of
+	// the next hop link, which does not fragment.
+	DropMTU
+	// DropNAT is a drop by NAT of a packet that has no mapping or, if sent
+	// from inside, no free port.
+	DropNAT
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropLoss:
+		return "loss"
+	case DropQueue:
+		return "queue"
+	case DropPartition:
+		return "partition"
+	case DropReadBuffer:
+		return "read buffer"
+	case DropWriteBuffer:
+		return "write buffer"
+	case DropNoListener:
+		return "no listener"
+	case DropIntercept:
+		return "intercept"
+	case DropFirewall:
+		return "firewall"
+	case DropNoRoute:
+		return "no route"
+	case DropTTL:
+		return "ttl"
+	case DropMTU:
+		return "mtu"
+	case DropNAT:
+		return "nat"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats is a snapshot of traffic counters of a socket, a link or the whole
+// network.
+type Stats struct {
+	PacketsSent     uint64
+	BytesSent       uint64
+	PacketsReceived uint64
+	BytesReceived   uint64
+
+	// Drops is the number of dropped packets by reason.
+	//
+	// Sockets count drops of packets they sent, except DropReadBuffer, which
+	// is counted by the receiving socket. Links count DropLoss, DropQueue,
+	// DropPartition, DropNAT of outbound packets and, if the path is routed,
+	// DropNoRoute, DropTTL and DropMTU.
+	Drops map[DropReason]uint64
+
+	// QueueHighWater is the maximum number of queued packets: in the receive
+	// buffer for socket and in the bottleneck queue for link.
+	QueueHighWater int
+}
+
+// counters are traffic counters safe for concurrent use.
+type counters struct {
+	mux   sync.Mutex
+	stats Stats
+}
+
+func (c *counters) snapshot() Stats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	s := c.stats
+	s.Drops = map[DropReason]uint64{}
+	for r, v := range c.stats.Drops {
+		s.Drops[r] = v
+	}
+	return s
+}
+
+func (c *counters) sent(packets, bytes int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.stats.PacketsSent += uint64(packets)
+	c.stats.BytesSent += uint64(bytes)
+}
+
+func (c *counters) received(packets, bytes int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.stats.PacketsReceived += uint64(packets)
+	c.stats.BytesReceived += uint64(bytes)
+}
+
+// receivedQueued counts a received packet of the given size that was queued
+// with n packets in the queue.
+func (c *counters) receivedQueued(size, n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.stats.PacketsReceived++
+	c.stats.BytesReceived += uint64(size)
+	if n > c.stats.QueueHighWater {
+		c.stats.QueueHighWater = n
+	}
+}
+
+func (c *counters) drop(r DropReason) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.stats.Drops == nil {
+		c.stats.Drops = map[DropReason]uint64{}
+	}
+	c.stats.Drops[r]++
+}
+
+// queued updates high-water mark with current queue length.
+func (c *counters) queued(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if n > c.stats.QueueHighWater {
+		c.stats.QueueHighWater = n
+	}
+}
+
+// Stats returns snapshot of socket counters.
+func (c *PacketConn) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// drop counts a packet dropped for the given reason by socket and Net.
+func (c *PacketConn) drop(r DropReason) {
+	c.stats.drop(r)
+	c.net.stats.drop(r)
+}
+
+// Stats returns snapshot of counters of all sockets of the network.
+func (n *Net) Stats() Stats {
+	return n.stats.snapshot()
+}
+
+// LinkStats returns snapshot of counters of the one-way path from one IP to
+// another. Packets are counted as received when they arrive at the far end of
+// the path, even if no socket receives them.
+func (n *Net) LinkStats(from, to net.IP) Stats {
+	n.mux.Lock()
+	c, ok := n.linkStats[linkKey{from: ipKey(from), to: ipKey(to)}]
+	n.mux.Unlock()
+
+	if !ok {
+		return Stats{Drops: map[DropReason]uint64{}}
+	}
+	return c.snapshot()
+}
+
+// linkStatsUnlocked returns counters of the path from one IP to another.
+func (n *Net) linkStatsUnlocked(from, to net.IP) *counters {
+	k := linkKey{from: ipKey(from), to: ipKey(to)}
+	c, ok := n.linkStats[k]
+	if !ok {
+		c = &counters{}
+		n.linkStats[k] = c
+	}
+	return c
+}
@@ -0,0 +1,84 @@
+package neo
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNet_Stats(t *testing.T) {
+	nt := NewNet(nil)
+	nt.Seed(1)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+	if err := left.SetReadBufferSize(BufferSize{Packets: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := right.WriteTo([]byte("nobody"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := right.Stats()
+	if sent.PacketsSent != 4 || sent.BytesSent != 21 {
+		t.Errorf("sent %d packets, %d bytes", sent.PacketsSent, sent.BytesSent)
+	}
+	if sent.Drops[DropNoListener] != 1 {
+		t.Errorf("got %d no listener drops, expected 1", sent.Drops[DropNoListener])
+	}
+
+	received := left.Stats()
+	if received.PacketsReceived != 2 || received.BytesReceived != 10 {
+		t.Errorf("received %d packets, %d bytes", received.PacketsReceived, received.BytesReceived)
+	}
+	if received.Drops[DropReadBuffer] != 1 {
+		t.Errorf("got %d read buffer drops, expected 1", received.Drops[DropReadBuffer])
+	}
+	if received.QueueHighWater != 2 {
+		t.Errorf("got %d high water, expected 2", received.QueueHighWater)
+	}
+
+	total := nt.Stats()
+	if total.PacketsSent != 4 || total.PacketsReceived != 2 {
+		t.Errorf("network sent %d, received %d", total.PacketsSent, total.PacketsReceived)
+	}
+	if total.Drops[DropReadBuffer] != 1 || total.Drops[DropNoListener] != 1 {
+		t.Errorf("bad network drops: %v", total.Drops)
+	}
+
+	// Link counts all packets that reached its far end.
+	link := nt.LinkStats(net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1))
+	if link.PacketsSent != 4 || link.PacketsReceived != 4 {
+		t.Errorf("link sent %d, received %d", link.PacketsSent, link.PacketsReceived)
+	}
+	if reverse := nt.LinkStats(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)); reverse.PacketsSent != 0 {
+		t.Errorf("reverse link sent %d packets", reverse.PacketsSent)
+	}
+}
+
+func TestNet_LinkStatsDrops(t *testing.T) {
+	nt := NewNet(nil)
+	left := listen(t, nt, "10.0.0.1:123")
+	right := listen(t, nt, "10.0.0.2:123")
+
+	nt.SetLink(right.host.addrs[0].IP, left.host.addrs[0].IP, Link{Loss: 1})
+	if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	nt.Partition([]net.IP{net.IPv4(10, 0, 0, 1)}, []net.IP{net.IPv4(10, 0, 0, 2)})
+	if _, err := right.WriteTo([]byte("hello"), left.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	link := nt.LinkStats(net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1))
+	if link.Drops[DropLoss] != 1 || link.Drops[DropPartition] != 1 {
+		t.Errorf("bad link drops: %v", link.Drops)
+	}
+	if link.PacketsReceived != 0 {
+		t.Errorf("link received %d packets", link.PacketsReceived)
+	}
+}
@@ -65,6 +65,7 @@ func (t *Time) planUnlocked(when time.Time, do func(now time.Time)) int {
 	id := t.momentID
 	t.momentID++
 	t.moments[id] = moment{
+		id:   id,
 		when: when,
 		do:   do,
 	}
@@ -79,6 +80,27 @@ func (t *Time) plan(when time.Time, do func(now time.Time)) int {
 	return t.planUnlocked(when, do)
 }
 
+// planAfter is like plan but schedules the moment d after the current time.
+func (t *Time) planAfter(d time.Duration, do func(now time.Time)) int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.planUnlocked(t.now.Add(d), do)
+}
+
+// planOrRun is like plan but runs do immediately if when is not after the
+// current time. Note that do runs under Time’s lock in both cases.
+func (t *Time) planOrRun(when time.Time, do func(now time.Time)) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if !when.After(t.now) {
+		do(t.now)
+		return
+	}
+	t.planUnlocked(when, do)
+}
+
 // stop removes the moment with the given ID from the list of scheduled moments.
 // It returns true if a moment existed for the given ID, otherwise it is no-op.
 func (t *Time) stop(id int) bool {
@@ -107,7 +129,7 @@ func (t *Time) resetUnlocked(d time.Duration, id int, do func(now time.Time), du
 
 	m, ok := t.moments[id]
 	if !ok {
-		m = moment{do: do}
+		m = moment{id: id, do: do}
 	}
 
 	m.when = t.now.Add(d)
@@ -0,0 +1,279 @@
+package neo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Shape is a shape of topology built by Net.Build.
+type Shape int
+
+// Topology shapes.
+const (
+	// Star connects every host to the first one, the hub.
+	Star Shape = iota + 1
+	// Ring connects every host to the next one and the last host to the
+	// first one.
+	Ring
+	// FullMesh connects every pair of hosts.
+	FullMesh
+	// TwoDatacenters is a pair of full meshes of Hosts hosts each. Hosts of
+	// different datacenters are connected by WAN link.
+	TwoDatacenters
+	// RandomGraph connects every pair of hosts with probability P.
+	RandomGraph
+)
+
+func (s Shape) String() string {
+	switch s {
+	case Star:
+		return "star"
+	case Ring:
+		return "ring"
+	case FullMesh:
+		return "full mesh"
+	case TwoDatacenters:
+		return "two datacenters"
+	case RandomGraph:
+		return "random graph"
+	default:
+		return "unknown"
+	}
+}
+
+// Topology describes a cluster of hosts built by Net.Build.
+//
+// Adjacent hosts are connected by Link in both directions. Packets between
+// hosts that are not adjacent travel the shortest path, so their link is Link
+// repeated for every hop: latencies add up and so do chances of loss,
+// duplication and corruption. Traffic between hosts that have no path is
+// dropped as crossing a partition, which Heal does not remove.
+//
+// Every pair of hosts has its own link with its own bottleneck queue, so
+// pairs do not share bandwidth of hops their paths have in common, and path
+// of several hops has Bandwidth and MTU of a single Link.
+type Topology struct {
+	Shape Shape
+	// Hosts is the number of hosts, in every datacenter for TwoDatacenters.
+	Hosts int
+	// CIDR is the subnet, like 10.0.0.0/24, that hosts get addresses from
+	// in order, starting with the first address after the network one.
+	CIDR string
+
+	// Link is the model of links between adjacent hosts.
+	Link Link
+	// WAN is the model of links between datacenters of TwoDatacenters.
+	WAN Link
+
+	// P is the probability of edge between two hosts of RandomGraph.
+	P float64
+	// Seed seeds the random source of RandomGraph.
+	Seed int64
+}
+
+// Build adds hosts of topology t to the network and returns them in order of
+// their addresses. For TwoDatacenters, the first half of hosts is the first
+// datacenter. If Build fails, it adds nothing.
+func (n *Net) Build(t Topology) ([]*Host, error) {
+	if t.Hosts <= 0 {
+		return nil, errors.New("no hosts")
+	}
+	_, subnet, err := net.ParseCIDR(t.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	count := t.Hosts
+	if t.Shape == TwoDatacenters {
+		count *= 2
+	}
+	edges, err := t.edges(count)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := n.newSubnetHosts(subnet, count)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.addHosts(hosts...); err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, count)
+	for i, h := range hosts {
+		ips[i] = h.addrs[0].IP
+	}
+
+	for i, hops := range distances(edges) {
+		for j, d := range hops {
+			switch {
+			case i == j:
+			case t.Shape == TwoDatacenters && i/t.Hosts != j/t.Hosts:
+				n.SetLink(ips[i], ips[j], t.WAN)
+			case d == 0:
+				n.unlink(ips[i], ips[j])
+			default:
+				n.SetLink(ips[i], ips[j], seriesLink(t.Link, d))
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// newSubnetHosts returns count hosts with consecutive addresses of subnet,
+// starting with the first address after the network one, without adding
+// them.
+func (n *Net) newSubnetHosts(subnet *net.IPNet, count int) ([]*Host, error) {
+	ones, _ := subnet.Mask.Size()
+	hosts := make([]*Host, count)
+	for i := range hosts {
+		ip, ok := nthAddr(subnet, i+1)
+		if !ok {
+			return nil, fmt.Errorf("%d hosts do not fit into %s", count, subnet)
+		}
+		h, err := n.newHost(false, []string{fmt.Sprintf("%s/%d", ip, ones)})
+		if err != nil {
+			return nil, err
+		}
+		hosts[i] = h
+	}
+	return hosts, nil
+}
+
+// edges returns adjacency matrix of count hosts.
+func (t Topology) edges(count int) ([][]bool, error) {
+	edges := make(adjacency, count)
+	for i := range edges {
+		edges[i] = make([]bool, count)
+	}
+	switch t.Shape {
+	case Star:
+		for i := 1; i < count; i++ {
+			edges.connect(0, i)
+		}
+	case Ring:
+		for i := 0; i < count; i++ {
+			edges.connect(i, (i+1)%count)
+		}
+	case FullMesh:
+		edges.mesh(count)
+	case TwoDatacenters:
+		// Datacenters are connected by WAN instead of edges.
+		edges.mesh(t.Hosts)
+	case RandomGraph:
+		if t.P < 0 || t.P > 1 {
+			return nil, errors.New("bad edge probability")
+		}
+		edges.random(rand.New(rand.NewSource(t.Seed)), t.P)
+	default:
+		return nil, errors.New("bad shape")
+	}
+	return edges, nil
+}
+
+// adjacency is adjacency matrix of undirected graph.
+type adjacency [][]bool
+
+func (a adjacency) connect(i, j int) {
+	if i != j {
+		a[i][j], a[j][i] = true, true
+	}
+}
+
+// mesh connects every pair of nodes within consecutive groups of size.
+func (a adjacency) mesh(size int) {
+	for i := range a {
+		for j := i + 1; j < len(a) && j/size == i/size; j++ {
+			a.connect(i, j)
+		}
+	}
+}
+
+// random connects every pair of nodes with probability p.
+func (a adjacency) random(r *rand.Rand, p float64) {
+	for i := range a {
+		for j := i + 1; j < len(a); j++ {
+			if r.Float64() < p {
+				a.connect(i, j)
+			}
+		}
+	}
+}
+
+// distances returns lengths of shortest paths between every pair of nodes,
+// zero if there is no path.
+func distances(edges [][]bool) [][]int {
+	dist := make([][]int, len(edges))
+	for from := range edges {
+		dist[from] = make([]int, len(edges))
+		queue := []int{from}
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			for j, ok := range edges[i] {
+				if ok && j != from && dist[from][j] == 0 {
+					dist[from][j] = dist[from][i] + 1
+					queue = append(queue, j)
+				}
+			}
+		}
+	}
+	return dist
+}
+
+// nthAddr returns address of subnet with the given offset. It returns false
+// if the address is out of subnet or, for IPv4, is the broadcast one.
+func nthAddr(subnet *net.IPNet, offset int) (net.IP, bool) {
+	ip := append(net.IP(nil), normalizeIP(subnet.IP)...)
+	carry := offset
+	for i := len(ip) - 1; i >= 0 && carry > 0; i-- {
+		carry += int(ip[i])
+		ip[i] = byte(carry)
+		carry >>= 8
+	}
+	if carry > 0 || !subnet.Contains(ip) {
+		return nil, false
+	}
+	if b := broadcastIP(*subnet); b != nil && b.Equal(ip) {
+		return nil, false
+	}
+	return ip, true
+}
+
+// seriesLink returns link equivalent to n links l in series.
+func seriesLink(l Link, n int) Link {
+	if n == 1 {
+		return l
+	}
+	if l.Latency != nil {
+		l.Latency = seriesLatency{Latency: l.Latency, n: n}
+	}
+	l.Loss = seriesChance(l.Loss, n)
+	l.Duplicate = seriesChance(l.Duplicate, n)
+	l.Reorder = seriesChance(l.Reorder, n)
+	l.Corrupt = seriesChance(l.Corrupt, n)
+	return l
+}
+
+// seriesChance returns probability of event with probability p to happen at
+// least once in n tries.
+func seriesChance(p float64, n int) float64 {
+	return 1 - math.Pow(1-p, float64(n))
+}
+
+// seriesLatency is the sum of n samples of Latency.
+type seriesLatency struct {
+	Latency
+	n int
+}
+
+// Delay implements Latency.
+func (l seriesLatency) Delay(r *rand.Rand) time.Duration {
+	var d time.Duration
+	for i := 0; i < l.n; i++ {
+		d += l.Latency.Delay(r)
+	}
+	return d
+}
@@ -0,0 +1,136 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNet_Build(t *testing.T) {
+	link := Link{Latency: FixedLatency(10 * time.Millisecond)}
+	for _, tt := range []struct {
+		Topology Topology
+		// Latency from the first host to the others.
+		Latency []time.Duration
+	}{
+		{
+			Topology: Topology{Shape: Star, Hosts: 4},
+			Latency:  []time.Duration{10, 10, 10},
+		},
+		{
+			Topology: Topology{Shape: Ring, Hosts: 5},
+			Latency:  []time.Duration{10, 20, 20, 10},
+		},
+		{
+			Topology: Topology{Shape: FullMesh, Hosts: 3},
+			Latency:  []time.Duration{10, 10},
+		},
+		{
+			Topology: Topology{Shape: TwoDatacenters, Hosts: 2, WAN: Link{Latency: FixedLatency(50 * time.Millisecond)}},
+			Latency:  []time.Duration{10, 50, 50},
+		},
+	} {
+		t.Run(tt.Topology.Shape.String(), func(t *testing.T) {
+			nt := NewNet(nil)
+			tt.Topology.CIDR = "10.0.0.0/24"
+			tt.Topology.Link = link
+			hosts, err := nt.Build(tt.Topology)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hosts) != len(tt.Latency)+1 {
+				t.Fatalf("got %d hosts", len(hosts))
+			}
+			for i, h := range hosts {
+				addr := h.Addrs()[0]
+				if want := net.IPv4(10, 0, 0, byte(i+1)); !addr.IP.Equal(want) || addr.Mask.String() != "ffffff00" {
+					t.Errorf("got address %s, expected %s/24", addr.String(), want)
+				}
+			}
+			from := hosts[0].Addrs()[0].IP
+			for i, h := range hosts[1:] {
+				l, _ := nt.linkUnlocked(from, h.Addrs()[0].IP)
+				if got, want := l.Latency.Delay(nil), tt.Latency[i]*time.Millisecond; got != want {
+					t.Errorf("host %d: got latency %s, expected %s", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNet_BuildInUse(t *testing.T) {
+	nt := NewNet(nil)
+	if _, err := nt.AddHost("10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+	// The third host of topology fails, so none of them is added.
+	if _, err := nt.Build(Topology{Shape: Star, Hosts: 4, CIDR: "10.0.0.0/24"}); !errors.Is(err, errAddrInUse) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(nt.hosts) != 1 {
+		t.Errorf("got %d hosts, expected 1", len(nt.hosts))
+	}
+	if _, err := nt.AddHost("10.0.0.1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNet_BuildRandomGraph(t *testing.T) {
+	nt := NewNet(nil)
+	hosts, err := nt.Build(Topology{
+		Shape: RandomGraph,
+		Hosts: 8,
+		CIDR:  "10.0.0.0/29",
+		Link:  Link{Loss: 0.1},
+		P:     0.2,
+		Seed:  1,
+	})
+	if err == nil {
+		t.Fatalf("built %d hosts that do not fit", len(hosts))
+	}
+
+	topology := Topology{Shape: RandomGraph, Hosts: 8, CIDR: "10.0.0.0/28", Link: Link{Loss: 0.1}, P: 0.2, Seed: 1}
+	if hosts, err = nt.Build(topology); err != nil {
+		t.Fatal(err)
+	}
+	// Hosts without path stay disconnected after partitions heal.
+	nt.Heal()
+	edges, _ := topology.edges(len(hosts))
+	dist := distances(edges)
+	for i, a := range hosts {
+		for j, b := range hosts {
+			if i == j {
+				continue
+			}
+			from, to := a.Addrs()[0].IP, b.Addrs()[0].IP
+			if dist[i][j] == 0 {
+				if !nt.isBlockedUnlocked(from, to) {
+					t.Errorf("%s -> %s: expected partition", from, to)
+				}
+				continue
+			}
+			l, _ := nt.linkUnlocked(from, to)
+			if want := seriesLink(topology.Link, dist[i][j]).Loss; l.Loss != want {
+				t.Errorf("%s -> %s: got loss %f, expected %f", from, to, l.Loss, want)
+			}
+		}
+	}
+}
+
+func TestNthAddr(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.252/30")
+	for offset, want := range []string{"10.0.0.252", "10.0.0.253", "10.0.0.254", "", ""} {
+		ip, ok := nthAddr(subnet, offset)
+		if ok != (want != "") || (ok && ip.String() != want) {
+			t.Errorf("%d: got %s, %v, expected %q", offset, ip, ok, want)
+		}
+	}
+	_, subnet, _ = net.ParseCIDR("fd00::ff/120")
+	if ip, ok := nthAddr(subnet, 256); ok {
+		t.Errorf("got %s out of subnet", ip)
+	}
+	if ip, _ := nthAddr(subnet, 255); ip.String() != "fd00::ff" {
+		t.Errorf("got %s", ip)
+	}
+}
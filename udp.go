@@ -0,0 +1,210 @@
+package neo
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+)
+
+// UDPConn is the method set of *net.UDPConn that PacketConn implements, so
+// code that depends on it can use both real and virtual sockets.
+type UDPConn interface {
+	net.PacketConn
+
+	// Methods of net.Conn.
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	RemoteAddr() net.Addr
+
+	ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+	WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+	ReadFromUDPAddrPort(b []byte) (n int, addr netip.AddrPort, err error)
+	WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error)
+	ReadMsgUDPAddrPort(b, oob []byte) (n, oobn, flags int, addr netip.AddrPort, err error)
+	WriteMsgUDPAddrPort(b, oob []byte, addr netip.AddrPort) (n, oobn int, err error)
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+var (
+	_ UDPConn = (*net.UDPConn)(nil)
+	_ UDPConn = (*PacketConn)(nil)
+)
+
+// ListenUDP is like ListenPacket but takes UDP address and returns
+// *PacketConn, like net.ListenUDP.
+func (n *Net) ListenUDP(network string, laddr *net.UDPAddr) (*PacketConn, error) {
+	if laddr == nil {
+		laddr = &net.UDPAddr{}
+	}
+	c, err := n.ListenPacket(network, laddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return c.(*PacketConn), nil
+}
+
+// RemoteAddr returns the remote address of connected socket or nil.
+func (c *PacketConn) RemoteAddr() net.Addr {
+	if !c.ok() {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.raddr
+}
+
+// Read reads a packet like ReadFrom, discarding its source address.
+func (c *PacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// Write writes a packet to the remote address of connected socket. Like on
+// unconnected UDP socket, it fails with EDESTADDRREQ if there is none.
+func (c *PacketConn) Write(b []byte) (int, error) {
+	if !c.ok() {
+		return 0, syscall.EINVAL
+	}
+	raddr := c.RemoteAddr()
+	if raddr == nil {
+		return 0, c.opError("write", nil, os.NewSyscallError("write", errDestAddrReq))
+	}
+	return c.write(b, raddr, nil)
+}
+
+// ReadFromUDP is like ReadFrom but returns UDP address.
+func (c *PacketConn) ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error) {
+	n, _, _, addr, err = c.ReadMsgUDP(b, nil)
+	return n, addr, err
+}
+
+// WriteToUDP is like WriteTo but takes UDP address.
+func (c *PacketConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	if !c.ok() {
+		return 0, syscall.EINVAL
+	}
+	if err := c.checkUnconnected(addr); err != nil {
+		return 0, err
+	}
+	return c.write(b, addr, nil)
+}
+
+// checkUnconnected returns error of write to a if c is connected, like
+// net.UDPConn does.
+func (c *PacketConn) checkUnconnected(a net.Addr) error {
+	if c.RemoteAddr() == nil {
+		return nil
+	}
+	return c.opError("write", a, net.ErrWriteToConnected)
+}
+
+// ReadMsgUDP reads a packet like ReadFrom. If b is too small, the packet is
+// truncated and flags have MSG_TRUNC set.
+//
+// Control messages enabled by SetControlMessage are copied into oob, see
+// ParseControlMessage. If oob is too small, they are truncated and flags have
+// MSG_CTRUNC set.
+func (c *PacketConn) ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error) {
+	if !c.ok() {
+		return 0, 0, 0, nil, syscall.EINVAL
+	}
+	var ps [1]packet
+	if _, err := c.read(ps[:]); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	n, oobn, flags = c.readMsg(ps[0], [][]byte{b}, oob)
+	return n, oobn, flags, ps[0].addr.(*net.UDPAddr), nil
+}
+
+// readMsg copies payload of p into buffers and enabled control messages into
+// oob, and releases p. It returns numbers of copied bytes and message flags.
+func (c *PacketConn) readMsg(p packet, buffers [][]byte, oob []byte) (n, oobn, flags int) {
+	for _, b := range buffers {
+		n += copy(b, p.buf[n:])
+	}
+	if n < len(p.buf) {
+		flags |= msgTrunc
+	}
+
+	c.mux.Lock()
+	cf := c.cmsg
+	c.mux.Unlock()
+	if cf != 0 && p.dst != nil {
+		cm := &ControlMessage{}
+		if cf&FlagTTL != 0 {
+			cm.TTL = p.ttl
+		}
+		if cf&FlagDst != 0 {
+			cm.Dst = p.dst.IP
+		}
+		msg := cm.marshal(ipFamily(p.dst.IP))
+		oobn = copy(oob, msg)
+		if oobn < len(msg) {
+			flags |= msgCtrunc
+		}
+	}
+	p.release()
+	return n, oobn, flags
+}
+
+// WriteMsgUDP writes a packet to addr or, if addr is nil, to the remote
+// address of connected socket. Source address and TTL of the packet may be
+// set by control message in oob, see ControlMessage.
+func (c *PacketConn) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error) {
+	if !c.ok() {
+		return 0, 0, syscall.EINVAL
+	}
+	var a net.Addr = addr
+	if addr == nil {
+		if a = c.RemoteAddr(); a == nil {
+			return 0, 0, c.opError("write", nil, os.NewSyscallError("sendmsg", errDestAddrReq))
+		}
+	} else if err := c.checkUnconnected(a); err != nil {
+		return 0, 0, err
+	}
+	cm, err := ParseControlMessage(oob)
+	if err != nil {
+		return 0, 0, c.opError("write", a, os.NewSyscallError("sendmsg", err))
+	}
+	if n, err = c.write(b, a, cm); err != nil {
+		return 0, 0, err
+	}
+	return n, len(oob), nil
+}
+
+// ReadFromUDPAddrPort is like ReadFrom but returns netip.AddrPort.
+func (c *PacketConn) ReadFromUDPAddrPort(b []byte) (n int, addr netip.AddrPort, err error) {
+	n, _, _, addr, err = c.ReadMsgUDPAddrPort(b, nil)
+	return n, addr, err
+}
+
+// WriteToUDPAddrPort is like WriteTo but takes netip.AddrPort.
+func (c *PacketConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	if !c.ok() {
+		return 0, syscall.EINVAL
+	}
+	a := net.UDPAddrFromAddrPort(addr)
+	if err := c.checkUnconnected(a); err != nil {
+		return 0, err
+	}
+	return c.write(b, a, nil)
+}
+
+// ReadMsgUDPAddrPort is like ReadMsgUDP but returns netip.AddrPort.
+func (c *PacketConn) ReadMsgUDPAddrPort(b, oob []byte) (n, oobn, flags int, addr netip.AddrPort, err error) {
+	n, oobn, flags, a, err := c.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, 0, 0, netip.AddrPort{}, err
+	}
+	ip, _ := netip.AddrFromSlice(a.IP)
+	return n, oobn, flags, netip.AddrPortFrom(ip.WithZone(a.Zone), uint16(a.Port)), nil
+}
+
+// WriteMsgUDPAddrPort is like WriteMsgUDP but takes netip.AddrPort.
+func (c *PacketConn) WriteMsgUDPAddrPort(b, oob []byte, addr netip.AddrPort) (n, oobn int, err error) {
+	return c.WriteMsgUDP(b, oob, net.UDPAddrFromAddrPort(addr))
+}
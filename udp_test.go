@@ -0,0 +1,170 @@
+package neo
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+func TestPacketConn_UDP(t *testing.T) {
+	nt := NewNet(nil)
+	server, err := nt.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := listen(t, nt, "10.0.0.2:123")
+
+	if _, err := client.WriteToUDP([]byte("ping"), server.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, addr, err := server.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" || addr.String() != "10.0.0.2:123" {
+		t.Errorf("got %q from %s", buf[:n], addr)
+	}
+
+	if server.RemoteAddr() != nil {
+		t.Errorf("unexpected remote address %s", server.RemoteAddr())
+	}
+	if _, err := server.Write([]byte("pong")); !errors.Is(err, errDestAddrReq) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := server.WriteToUDP([]byte("pong"), nil); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPacketConn_ControlMessage(t *testing.T) {
+	nt := NewNet(nil)
+	h, err := nt.AddHost("10.0.0.1/24", "192.168.1.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := h.ListenPacket("udp", "0.0.0.0:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := c.(*PacketConn)
+	if err := server.SetControlMessage(FlagTTL|FlagDst, true); err != nil {
+		t.Fatal(err)
+	}
+	client := listen(t, nt, "192.168.1.3:123")
+
+	if _, err := client.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}); err != nil {
+		t.Fatal(err)
+	}
+	buf, oob := make([]byte, 1024), make([]byte, 1024)
+	_, oobn, _, addr, err := server.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, err := ParseControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.TTL != defaultTTL || !cm.Dst.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("bad control message: %+v", cm)
+	}
+
+	// Reply from the address that request was sent to.
+	reply := &ControlMessage{Src: cm.Dst, TTL: 3}
+	if _, _, err := server.WriteMsgUDP([]byte("pong"), reply.Marshal(), addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetControlMessage(FlagTTL, true); err != nil {
+		t.Fatal(err)
+	}
+	_, oobn, _, addr, err = client.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "10.0.0.1:53" {
+		t.Errorf("got reply from %s", addr)
+	}
+	if cm, err = ParseControlMessage(oob[:oobn]); err != nil {
+		t.Fatal(err)
+	}
+	if cm.TTL != 3 || cm.Dst != nil {
+		t.Errorf("bad control message: %+v", cm)
+	}
+
+	// Source address must belong to the host.
+	bad := &ControlMessage{Src: net.IPv4(10, 0, 0, 2)}
+	if _, _, err := server.WriteMsgUDP([]byte("pong"), bad.Marshal(), addr); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Control messages are truncated by small oob.
+	if _, err := client.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 53}); err != nil {
+		t.Fatal(err)
+	}
+	_, oobn, flags, _, err := server.ReadMsgUDP(buf, oob[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oobn != 4 || flags&msgCtrunc == 0 {
+		t.Errorf("got oobn=%d flags=%#x", oobn, flags)
+	}
+}
+
+func TestControlMessage_Marshal(t *testing.T) {
+	for _, cm := range []*ControlMessage{
+		{TTL: 1, Dst: net.IPv4(10, 0, 0, 1).To4()},
+		{TTL: 255},
+		{Src: net.ParseIP("fd00::1"), Dst: net.ParseIP("fd00::1")},
+	} {
+		got, err := ParseControlMessage(cm.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TTL != cm.TTL || !got.Src.Equal(cm.Src) || !got.Dst.Equal(cm.Dst) {
+			t.Errorf("got %+v, expected %+v", got, cm)
+		}
+	}
+	if _, err := ParseControlMessage([]byte{1, 2, 3}); err == nil {
+		t.Error("short message parsed")
+	}
+}
+
+func TestPacketConn_AddrPort(t *testing.T) {
+	nt := NewNet(nil)
+	server := listen(t, nt, "[fe80::1%eth0]:53")
+	client := listen(t, nt, "[fe80::2%eth0]:123")
+
+	dst := netip.MustParseAddrPort("[fe80::1%eth0]:53")
+	if _, err := client.WriteToUDPAddrPort([]byte("ping"), dst); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, addr, err := server.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" || addr != netip.MustParseAddrPort("[fe80::2%eth0]:123") {
+		t.Errorf("got %q from %s", buf[:n], addr)
+	}
+
+	if _, _, err := server.WriteMsgUDPAddrPort([]byte("pong"), nil, addr); err != nil {
+		t.Fatal(err)
+	}
+	n, _, _, addr, err = client.ReadMsgUDPAddrPort(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" || addr != dst {
+		t.Errorf("got %q from %s", buf[:n], addr)
+	}
+
+	if _, err := client.WriteToUDPAddrPort([]byte("ping"), netip.AddrPort{}); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	v4 := listen(t, nt, "10.0.0.1:123")
+	if _, err := v4.WriteToUDPAddrPort([]byte("ping"), netip.MustParseAddrPort("10.0.0.2:53")); err != nil {
+		t.Fatal(err)
+	}
+}